@@ -35,31 +35,53 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"time"
 
+	"github.com/mwitkow/go-grpc-middleware"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_client/middleware"
 	pb "github.com/troylelandshields/helloworld_grpctooling_poc/helloworld"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
 )
 
 const (
 	address     = "localhost:50051"
 	defaultName = "world"
-	authKey     = "1"
 )
 
 func main() {
-	// Set up a connection to the server.
-	conn, err := grpc.Dial(address, grpc.WithInsecure())
+	// Set up a connection to the server with the same kind of middleware the server uses.
+	conn, err := grpc.Dial(address,
+		grpc.WithInsecure(),
+		grpc.WithStatsHandler(metrics.NewClientStatsHandler(metrics.Default)),
+		grpc.WithUnaryInterceptor(grpc_middleware.ChainUnaryClient(
+			middleware.UnaryRequestID,
+			middleware.UnaryTracing,
+			middleware.UnaryLogging,
+			middleware.UnaryMetrics,
+			middleware.UnaryAuth(),
+			middleware.UnaryDeadline(10*time.Second),
+		)),
+		grpc.WithStreamInterceptor(grpc_middleware.ChainStreamClient(
+			middleware.StreamRequestID,
+			middleware.StreamTracing,
+			middleware.StreamLogging,
+			middleware.StreamMetrics,
+			middleware.StreamAuth(),
+			middleware.StreamDeadline(time.Minute),
+		)),
+	)
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
 	defer conn.Close()
 	c := pb.NewGreeterClient(conn)
 
-	ctx := metadata.NewContext(context.Background(), metadata.Pairs(authKey, "user123"))
+	ctx := middleware.WithUser(context.Background(), "user123")
 
 	// Contact the server and print out its response.
 	names := []string{defaultName}
@@ -78,6 +100,9 @@ func main() {
 
 	fmt.Println("Say hello to all my friends:")
 	sayHelloToAllMyFriends(c, ctx)
+
+	fmt.Println("Client metrics:")
+	metrics.Default.WriteText(os.Stdout)
 }
 
 func sayHelloWorld(c pb.GreeterClient, ctx context.Context) {
@@ -122,4 +147,9 @@ func sayHelloToAllMyFriends(c pb.GreeterClient, ctx context.Context) {
 		log.Printf("Greeting: %s\n\n", r.Message)
 	}
 	sm.CloseSend()
+
+	//Wait for the server to finish the stream so the middleware sees it end
+	if _, err := sm.Recv(); err != io.EOF {
+		log.Printf("error: stream did not end cleanly: %v", err)
+	}
 }
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"log"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+//UnaryLogging for handling logging for unary gRPC calls
+func UnaryLogging(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	start := time.Now()
+	log.Printf("Log: Calling %s%s\n", method, traceIDs(ctx))
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+
+	log.Printf("Log: %s completed in %s, err: %v%s\n", method, time.Since(start), err, traceIDs(ctx))
+	return err
+}
+
+//StreamLogging for handling logging for streaming gRPC calls
+func StreamLogging(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	log.Printf("Log: Opening stream %s%s\n", method, traceIDs(ctx))
+
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		log.Printf("Log: Could not open stream %s: %v\n", method, err)
+		return nil, err
+	}
+
+	newStream := wrapClientStream(cs)
+
+	newStream.RegisterSendMiddleware(func(inner StreamHandler) StreamHandler {
+		//Log when messages are sent
+		return StreamFunc(func(m interface{}) error {
+			err := inner.Stream(m)
+
+			log.Printf("Log: Sent msg to %s: %v\n", method, m)
+			return err
+		})
+	})
+
+	newStream.RegisterRecvMiddleware(func(inner StreamHandler) StreamHandler {
+		//Log when messages are received
+		return StreamFunc(func(m interface{}) error {
+			err := inner.Stream(m)
+			if err != nil {
+				//io.EOF or an error means the stream is done
+				log.Printf("Log: %s completed, err: %v%s\n", method, err, traceIDs(ctx))
+				return err
+			}
+
+			log.Printf("Log: Received msg from %s: %v\n", method, m)
+			return nil
+		})
+	})
+
+	return newStream, nil
+}
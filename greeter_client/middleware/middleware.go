@@ -0,0 +1,171 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//clientMetrics are the call counters and latency histograms, labeled by type (unary or stream), method and status code,
+//plus the number of stream messages by method and direction
+type clientMetrics struct {
+	handled  *metrics.CounterVec
+	duration *metrics.HistogramVec
+	messages *metrics.CounterVec
+}
+
+func newClientMetrics(r *metrics.Registry) clientMetrics {
+	return clientMetrics{
+		handled:  r.Counter("grpc_client_handled_total", "Number of RPCs completed by the client, by method and status code.", "type", "method", "code"),
+		duration: r.Histogram("grpc_client_handling_seconds", "How long RPCs took to complete on the client, by method and status code.", metrics.DefBuckets, "type", "method", "code"),
+		messages: r.Counter("grpc_client_stream_msg_total", "Number of stream messages sent and received by the client, by method and direction.", "method", "direction"),
+	}
+}
+
+func (m clientMetrics) observe(callType, method string, start time.Time, err error) {
+	code := grpc.Code(err).String()
+	m.handled.Inc(callType, method, code)
+	m.duration.Observe(time.Since(start).Seconds(), callType, method, code)
+}
+
+var (
+	unaryMetrics  = NewUnaryMetrics(metrics.Default)
+	streamMetrics = NewStreamMetrics(metrics.Default)
+)
+
+//UnaryMetrics records unary calls to metrics.Default
+func UnaryMetrics(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return unaryMetrics(ctx, method, req, reply, cc, invoker, opts...)
+}
+
+//NewUnaryMetrics records the number of unary calls and how long they took to r
+func NewUnaryMetrics(r *metrics.Registry) grpc.UnaryClientInterceptor {
+	m := newClientMetrics(r)
+
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		now := time.Now()
+
+		err := invoker(ctx, method, req, reply, cc, opts...)
+
+		m.observe("unary", method, now, err)
+		return err
+	}
+}
+
+//StreamMetrics records streaming calls to metrics.Default
+func StreamMetrics(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamMetrics(ctx, desc, cc, method, streamer, opts...)
+}
+
+//NewStreamMetrics records the number of streaming calls, their messages and how long they took to r.
+//A stream is timed until it's done receiving, or until its context is done if that comes first.
+func NewStreamMetrics(r *metrics.Registry) grpc.StreamClientInterceptor {
+	m := newClientMetrics(r)
+
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		now := time.Now()
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			m.observe("stream", method, now, err)
+			return nil, err
+		}
+
+		newStream := wrapClientStream(cs)
+		newStream.onDone(ctx, desc, func(err error) {
+			m.observe("stream", method, now, err)
+		})
+		newStream.RegisterSendMiddleware(m.countMessages(method, "sent"))
+		newStream.RegisterRecvMiddleware(m.countMessages(method, "received"))
+
+		return newStream, nil
+	}
+}
+
+func (m clientMetrics) countMessages(method, direction string) func(StreamHandler) StreamHandler {
+	return func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(msg interface{}) error {
+			err := inner.Stream(msg)
+			if err == nil {
+				m.messages.Inc(method, direction)
+			}
+			return err
+		})
+	}
+}
+
+//UnaryDeadline adds a deadline to unary calls that don't already have an earlier one
+func UnaryDeadline(d time.Duration) grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		defer cancel()
+
+		return invoker(ctx, method, req, reply, cc, opts...)
+	}
+}
+
+//StreamDeadline adds a deadline to streaming calls that don't already have an earlier one.
+//The deadline covers the whole life of the stream, not just opening it.
+func StreamDeadline(d time.Duration) grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		ctx, cancel := context.WithTimeout(ctx, d)
+
+		cs, err := streamer(ctx, desc, cc, method, opts...)
+		if err != nil {
+			cancel()
+			return nil, err
+		}
+
+		//Release the context once the stream is done
+		newStream := wrapClientStream(cs)
+		newStream.onDone(ctx, desc, func(error) {
+			cancel()
+		})
+
+		return newStream, nil
+	}
+}
+
+const authKey = "1"
+
+type userKey struct{}
+
+//WithUser returns a ctx that the auth middleware will send user's credentials for
+func WithUser(ctx context.Context, user string) context.Context {
+	return context.WithValue(ctx, userKey{}, user)
+}
+
+//UnaryAuth adds the credentials of the user set with WithUser to the call's metadata
+func UnaryAuth() grpc.UnaryClientInterceptor {
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+		return invoker(withCredentials(ctx), method, req, reply, cc, opts...)
+	}
+}
+
+//StreamAuth adds the credentials of the user set with WithUser to the stream's metadata
+func StreamAuth() grpc.StreamClientInterceptor {
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		return streamer(withCredentials(ctx), desc, cc, method, opts...)
+	}
+}
+
+//withCredentials puts the user in ctx into the outgoing metadata. Calls without a user are left alone.
+func withCredentials(ctx context.Context) context.Context {
+	user, ok := ctx.Value(userKey{}).(string)
+	if !ok {
+		return ctx
+	}
+
+	md, ok := metadata.FromContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	md[authKey] = []string{user}
+
+	return metadata.NewContext(ctx, md)
+}
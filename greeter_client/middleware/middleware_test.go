@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+const testMethod = "/helloworld.Greeter/SayHelloToMany"
+
+//fakeClientStream is a grpc.ClientStream whose RecvMsg returns the messages in recv and then recvErr
+type fakeClientStream struct {
+	ctx     context.Context
+	recv    int
+	recvErr error
+}
+
+func (f *fakeClientStream) Header() (metadata.MD, error) { return nil, nil }
+func (f *fakeClientStream) Trailer() metadata.MD         { return nil }
+func (f *fakeClientStream) CloseSend() error             { return nil }
+func (f *fakeClientStream) Context() context.Context     { return f.ctx }
+func (f *fakeClientStream) SendMsg(m interface{}) error  { return nil }
+
+func (f *fakeClientStream) RecvMsg(m interface{}) error {
+	if f.recv == 0 {
+		return f.recvErr
+	}
+	f.recv--
+	return nil
+}
+
+var _ grpc.ClientStream = &fakeClientStream{}
+
+//handled returns how many streams the metrics recorded with code, or -1 if there aren't any
+func handled(r *metrics.Registry, code string) float64 {
+	for _, f := range r.Snapshot() {
+		if f.Name != "grpc_client_handled_total" {
+			continue
+		}
+		for _, s := range f.Series {
+			if s.LabelValues[2] == code {
+				return s.Value
+			}
+		}
+	}
+	return -1
+}
+
+func waitForHandled(t *testing.T, r *metrics.Registry, code string) {
+	deadline := time.Now().Add(time.Second)
+	for handled(r, code) != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("stream wasn't recorded as %s, snapshot %+v", code, r.Snapshot())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestStreamMetricsEnd(t *testing.T) {
+	tests := []struct {
+		name    string
+		desc    *grpc.StreamDesc
+		recv    int
+		recvErr error
+		//reads is how many times the caller calls RecvMsg
+		reads  int
+		cancel bool
+		want   string
+	}{
+		{"server stream ends with EOF", &grpc.StreamDesc{ServerStreams: true}, 2, io.EOF, 3, false, "OK"},
+		{"server stream fails", &grpc.StreamDesc{ServerStreams: true}, 1, grpc.Errorf(codes.Internal, "broken"), 2, false, "Internal"},
+		{"client stream gets its reply", &grpc.StreamDesc{ClientStreams: true}, 1, io.EOF, 1, false, "OK"},
+		{"abandoned and cancelled", &grpc.StreamDesc{ServerStreams: true}, 5, io.EOF, 1, true, "Canceled"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := metrics.NewRegistry()
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cs, err := NewStreamMetrics(r)(ctx, test.desc, nil, testMethod, func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+				return &fakeClientStream{ctx: ctx, recv: test.recv, recvErr: test.recvErr}, nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < test.reads; i++ {
+				cs.RecvMsg(nil)
+			}
+			if test.cancel {
+				if got := handled(r, "Canceled"); got != -1 {
+					t.Fatalf("stream recorded before it was done")
+				}
+				cancel()
+			}
+
+			waitForHandled(t, r, test.want)
+		})
+	}
+}
+
+func TestStreamDeadlineReleasesContext(t *testing.T) {
+	var streamCtx context.Context
+	cs, err := StreamDeadline(time.Hour)(context.Background(), &grpc.StreamDesc{ClientStreams: true}, nil, testMethod, func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+		streamCtx = ctx
+		return &fakeClientStream{ctx: ctx, recv: 1}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the only reply of a client stream ends it, there's no io.EOF to wait for
+	if err := cs.RecvMsg(nil); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-streamCtx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("context wasn't released after the reply")
+	}
+}
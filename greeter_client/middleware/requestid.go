@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//RequestIDKey is the metadata key the request ID is sent in
+const RequestIDKey = "x-request-id"
+
+//UnaryRequestID sends a newly generated request ID with every call and logs it
+func UnaryRequestID(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	return invoker(withRequestID(ctx, method), method, req, reply, cc, opts...)
+}
+
+//StreamRequestID sends a newly generated request ID with every stream and logs it
+func StreamRequestID(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return streamer(withRequestID(ctx, method), desc, cc, method, opts...)
+}
+
+//withRequestID adds a request ID to the outgoing metadata unless the caller already set one
+func withRequestID(ctx context.Context, method string) context.Context {
+	md, ok := metadata.FromContext(ctx)
+	if ok && len(md[RequestIDKey]) > 0 {
+		log.Printf("Request ID for %s: %s\n", method, md[RequestIDKey][0])
+		return ctx
+	}
+
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+
+	id := newRequestID()
+	md[RequestIDKey] = []string{id}
+	log.Printf("Request ID for %s: %s\n", method, id)
+
+	return metadata.NewContext(ctx, md)
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
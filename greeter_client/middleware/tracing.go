@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//UnaryTracing starts a client span for unary calls, as a child of the span in ctx if there is one, and sends it to the server
+//in the traceparent and tracestate headers. Spans go to tracing.DefaultTracer.
+func UnaryTracing(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, span := startClientSpan(ctx, method)
+	defer span.End()
+
+	err := invoker(ctx, method, req, reply, cc, opts...)
+
+	span.SetStatus(err)
+	return err
+}
+
+//StreamTracing starts a client span for streaming calls like UnaryTracing does, plus a child span for every message sent and received.
+//The stream's span ends when it's done receiving, or when its context is done if that comes first.
+func StreamTracing(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, span := startClientSpan(ctx, method)
+
+	cs, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		span.SetStatus(err)
+		span.End()
+		return nil, err
+	}
+
+	var sent, received int64
+	newStream := wrapClientStream(cs)
+
+	//Registered first so it sees the end of the stream after the recv span below has ended and counted the message
+	newStream.onDone(ctx, desc, func(err error) {
+		span.SetAttribute("messages.sent", strconv.FormatInt(atomic.LoadInt64(&sent), 10))
+		span.SetAttribute("messages.received", strconv.FormatInt(atomic.LoadInt64(&received), 10))
+		span.SetStatus(err)
+		span.End()
+	})
+
+	newStream.RegisterSendMiddleware(func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(m interface{}) error {
+			_, msgSpan := tracing.DefaultTracer.Start(ctx, method+" send", tracing.KindInternal)
+			defer msgSpan.End()
+
+			err := inner.Stream(m)
+			if err != nil {
+				msgSpan.SetStatus(err)
+				return err
+			}
+
+			msgSpan.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(&sent, 1), 10))
+			return nil
+		})
+	})
+
+	newStream.RegisterRecvMiddleware(func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(m interface{}) error {
+			_, msgSpan := tracing.DefaultTracer.Start(ctx, method+" recv", tracing.KindInternal)
+			defer msgSpan.End()
+
+			err := inner.Stream(m)
+			switch {
+			case err == nil:
+				msgSpan.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(&received, 1), 10))
+			case err == io.EOF:
+				msgSpan.SetAttribute("message.eof", "true")
+			default:
+				msgSpan.SetStatus(err)
+			}
+			return err
+		})
+	})
+
+	return newStream, nil
+}
+
+//startClientSpan starts the span and adds its context to the outgoing metadata
+func startClientSpan(ctx context.Context, method string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.DefaultTracer.Start(ctx, method, tracing.KindClient)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", method)
+
+	md, ok := metadata.FromContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	tracing.Inject(md, span.Context())
+
+	return metadata.NewContext(ctx, md), span
+}
+
+//traceIDs is added to log lines so they can be matched up with traces
+func traceIDs(ctx context.Context) string {
+	sc, ok := tracing.SpanContextFromContext(ctx)
+	if !ok {
+		return ""
+	}
+	return " traceID=" + sc.TraceID.String() + " spanID=" + sc.SpanID.String()
+}
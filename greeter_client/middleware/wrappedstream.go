@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"io"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+//Wraps the client stream that is used for sending/receiving messages.
+//Works the same way as the server's wrappedServerStream: chains are built on first use and reused for every message.
+type wrappedClientStream struct {
+	grpc.ClientStream
+	recvMsgDispatch   StreamHandler
+	sendMsgDispatch   StreamHandler
+	recvMsgMiddleware []func(StreamHandler) StreamHandler
+	sendMsgMiddleware []func(StreamHandler) StreamHandler
+}
+
+//wrapClientStream returns a ClientStream that messages middleware can be registered on
+func wrapClientStream(stream grpc.ClientStream) *wrappedClientStream {
+	if existing, ok := stream.(*wrappedClientStream); ok {
+		return existing
+	}
+	return &wrappedClientStream{
+		ClientStream: stream,
+	}
+}
+
+//RegisterRecvMiddleware adds middleware around RecvMsg. Middleware registered first runs first.
+func (w *wrappedClientStream) RegisterRecvMiddleware(middleware func(StreamHandler) StreamHandler) {
+	w.recvMsgMiddleware = append(w.recvMsgMiddleware, middleware)
+	w.recvMsgDispatch = nil
+}
+
+//RegisterSendMiddleware adds middleware around SendMsg. Middleware registered first runs first.
+func (w *wrappedClientStream) RegisterSendMiddleware(middleware func(StreamHandler) StreamHandler) {
+	w.sendMsgMiddleware = append(w.sendMsgMiddleware, middleware)
+	w.sendMsgDispatch = nil
+}
+
+//builds the stream middleware chain
+func buildChain(root func(interface{}) error, middleware []func(StreamHandler) StreamHandler) StreamHandler {
+	var handler StreamHandler
+	handler = StreamFunc(root)
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
+//SendMsg calls SendMsg on the underlying grpc.ClientStream but allows for middleware
+func (w *wrappedClientStream) SendMsg(m interface{}) error {
+	if w.sendMsgDispatch == nil {
+		w.sendMsgDispatch = buildChain(w.ClientStream.SendMsg, w.sendMsgMiddleware)
+	}
+	return w.sendMsgDispatch.Stream(m)
+}
+
+//RecvMsg calls RecvMsg on the underlying grpc.ClientStream but allows for middleware
+func (w *wrappedClientStream) RecvMsg(m interface{}) error {
+	if w.recvMsgDispatch == nil {
+		w.recvMsgDispatch = buildChain(w.ClientStream.RecvMsg, w.recvMsgMiddleware)
+	}
+	return w.recvMsgDispatch.Stream(m)
+}
+
+//onDone calls done once the stream is finished: when RecvMsg returns io.EOF or an error, when it returns the only reply of
+//a call the server doesn't stream, or when ctx is done first because the call was cancelled or abandoned.
+//done gets nil for a stream that ended cleanly.
+func (w *wrappedClientStream) onDone(ctx context.Context, desc *grpc.StreamDesc, done func(err error)) {
+	var once sync.Once
+	finished := make(chan struct{})
+	finish := func(err error) {
+		once.Do(func() {
+			close(finished)
+			done(err)
+		})
+	}
+
+	w.RegisterRecvMiddleware(func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(m interface{}) error {
+			err := inner.Stream(m)
+			switch {
+			case err == io.EOF:
+				finish(nil)
+			case err != nil:
+				finish(err)
+			case !desc.ServerStreams:
+				finish(nil)
+			}
+			return err
+		})
+	})
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			finish(contextError(ctx.Err()))
+		case <-finished:
+		}
+	}()
+}
+
+//contextError turns the error of a done context into the status error grpc returns for it
+func contextError(err error) error {
+	if err == context.DeadlineExceeded {
+		return grpc.Errorf(codes.DeadlineExceeded, err.Error())
+	}
+	return grpc.Errorf(codes.Canceled, err.Error())
+}
+
+//Middleware BS
+
+//StreamFunc implements stream
+type StreamFunc func(m interface{}) error
+
+func (s StreamFunc) Stream(m interface{}) error {
+	return s(m)
+}
+
+//StreamHandler can stream data
+type StreamHandler interface {
+	Stream(m interface{}) error
+}
@@ -34,20 +34,27 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware/wlogger"
 	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/server"
 	pb "github.com/troylelandshields/helloworld_grpctooling_poc/helloworld"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/otlp"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"github.com/weave-lab/wlib/wlog"
 	"golang.org/x/net/context"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/examples/helloworld/greeter_server/middleware"
 )
 
 const (
@@ -60,11 +67,16 @@ type greeterserver struct{}
 // SayHello implements helloworld.GreeterServer
 func (s *greeterserver) SayHello(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
 	fmt.Println("Responding to", in.Name)
+	tracing.SpanFromContext(ctx).SetAttribute("greeting.name", in.Name)
 	return &pb.HelloReply{Message: "Hello " + in.Name}, nil
 }
 
 //SayHelloSlow takes 5 seconds to say hello
 func (s *greeterserver) SayHelloSlow(ctx context.Context, in *pb.HelloRequest) (*pb.HelloReply, error) {
+	span := tracing.SpanFromContext(ctx)
+	span.SetAttribute("greeting.name", in.Name)
+	span.AddEvent("waiting")
+	middleware.Annotate(ctx, "waiting 5s before saying hello to %s", in.Name)
 	<-time.After(5 * time.Second)
 	return &pb.HelloReply{Message: "Helllllllooooooo " + in.Name}, nil
 }
@@ -91,36 +103,158 @@ func (s *greeterserver) SayHelloToMany(ss pb.Greeter_SayHelloToManyServer) error
 		}
 
 		fmt.Printf("Said hello to: %s\n", in.Name)
+		tracing.SpanFromContext(ss.Context()).AddEvent("said hello", tracing.Attribute{Key: "greeting.name", Value: in.Name})
 	}
 	return nil
 }
 
+var (
+	configPath = flag.String("config", "greeter_server/middleware.json", "middleware config file")
+	adminAddr  = flag.String("admin", ":8081", "address for the admin HTTP server")
+
+	statsdAddr   = flag.String("statsd", "", "address of a StatsD agent to send metrics to, e.g. 127.0.0.1:8125")
+	statsdPrefix = flag.String("statsd-prefix", "greeter.", "prefix for StatsD metric names")
+	statsdTags   = flag.String("statsd-tags", "", "comma separated key:value tags added to every StatsD metric")
+	dogStatsD    = flag.Bool("dogstatsd", false, "send StatsD tags in the DogStatsD format")
+
+	otlpEndpoint = flag.String("otlp", "", "OpenTelemetry collector to export spans and metrics to, e.g. localhost:4317 or http://localhost:4318")
+	otlpProtocol = flag.String("otlp-protocol", "grpc", "how to talk to the OpenTelemetry collector: grpc or http")
+)
+
+//newOTLP creates the OTLP exporter from the flags
+func newOTLP() (*otlp.Exporter, error) {
+	opts := []otlp.Option{
+		otlp.WithServiceName("greeter"),
+		otlp.WithMetrics(metrics.Default, 10*time.Second),
+	}
+
+	switch *otlpProtocol {
+	case "grpc":
+		return otlp.NewGRPC(*otlpEndpoint, opts...)
+	case "http":
+		return otlp.NewHTTP(*otlpEndpoint, opts...)
+	}
+	return nil, fmt.Errorf("unknown OTLP protocol %q, want grpc or http", *otlpProtocol)
+}
+
+//newStatsD creates the StatsD sink from the flags
+func newStatsD() (*metrics.StatsD, error) {
+	opts := []metrics.StatsDOption{metrics.WithPrefix(*statsdPrefix)}
+	if *dogStatsD {
+		opts = append(opts, metrics.WithDogStatsD())
+	}
+
+	for _, kv := range strings.Split(*statsdTags, ",") {
+		if kv == "" {
+			continue
+		}
+		parts := strings.SplitN(kv, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad StatsD tag %q, want key:value", kv)
+		}
+		opts = append(opts, metrics.WithTags(metrics.Tag{Key: parts[0], Value: parts[1]}))
+	}
+
+	return metrics.NewStatsD(*statsdAddr, opts...)
+}
+
 func main() {
+	flag.Parse()
+
+	//Log through wlog like the rest of our services
+	middleware.DefaultLogger = wlogger.New(wlog.NewWLogger(wlog.WlogdLogger))
+
 	lis, err := net.Listen("tcp", port)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
 
-	//Create a gRPC server with default middleware and add unary deadline and unary auth middleware too
-	s := server.New([]grpc.UnaryServerInterceptor{
-		middleware.UnaryUniversalDeadline(1 * time.Second),
-		middleware.UnaryAuth(),
-	}, nil)
+	//Send the middleware metrics to StatsD too
+	var sd *metrics.StatsD
+	if *statsdAddr != "" {
+		sd, err = newStatsD()
+		if err != nil {
+			log.Fatalf("failed to set up StatsD: %v", err)
+		}
+		middleware.DefaultMetricsSink = sd
+	}
+
+	//Export spans and metrics to an OpenTelemetry collector
+	var exporter *otlp.Exporter
+	if *otlpEndpoint != "" {
+		exporter, err = newOTLP()
+		if err != nil {
+			log.Fatalf("failed to set up OTLP: %v", err)
+		}
+		tracing.DefaultTracer.AddExporter(exporter)
+	}
+
+	//Create a gRPC server with default middleware and add the middleware from the config file too
+	cfg, err := middleware.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("failed to load middleware config: %v", err)
+	}
+
+	//The config is reloaded on SIGHUP
+	chain, err := server.NewChain(cfg)
+	if err != nil {
+		log.Fatalf("failed to apply middleware config: %v", err)
+	}
+
+	//Defaults go first so the request ID is set before the access log in the config runs
+	//The stats handler records wire sizes, which the interceptors can't see
+	s := server.New(
+		server.WithDefaultsFirst(),
+		server.WithChain(chain),
+		server.WithStatsHandler(metrics.NewServerStatsHandler(metrics.Default)),
+	)
 
 	pb.RegisterGreeterServer(s, &greeterserver{})
 
 	fmt.Println("Starting server...")
 	go s.Serve(lis)
 
-	//Listen for signal to execute graceful shutdown
+	//Admin HTTP server for debugging the running server
+	admin := http.NewServeMux()
+	admin.Handle("/debug/middleware", server.DescribeHandler(s))
+	admin.Handle("/debug/inflight", middleware.DefaultInFlight.Handler())
+	admin.Handle("/debug/requests", middleware.DefaultRequestLog.Handler())
+	admin.Handle("/metrics", metrics.Default.Handler())
+
+	fmt.Println("Starting admin server on", *adminAddr)
+	go func() {
+		log.Fatal(http.ListenAndServe(*adminAddr, admin))
+	}()
+
+	//Listen for signal to execute graceful shutdown or reload the config
 	signalChannel := make(chan os.Signal, 1)
-	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT)
+	signal.Notify(signalChannel, syscall.SIGTERM, syscall.SIGINT, syscall.SIGHUP)
 	for sig := range signalChannel {
 		switch sig {
+		case syscall.SIGHUP:
+			cfg, err := middleware.LoadConfig(*configPath)
+			if err != nil {
+				fmt.Println("failed to reload middleware config, keeping the old one:", err)
+				continue
+			}
+			if err := chain.Reload(cfg); err != nil {
+				fmt.Println("failed to apply middleware config, keeping the old one:", err)
+				continue
+			}
+			fmt.Println("reloaded middleware config...")
 		case syscall.SIGTERM, syscall.SIGINT:
 			fmt.Println("stopping server...")
 			s.GracefulStop()
 			fmt.Println("stopped server...")
+			//Send the last batch of metrics and spans
+			if sd != nil {
+				sd.Close()
+			}
+			if exporter != nil {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				exporter.Shutdown(ctx)
+				cancel()
+			}
 			os.Exit(0)
 		default:
 			fmt.Println("Received unknown signal")
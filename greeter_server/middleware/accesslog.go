@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//AccessLogLine is the JSON line written for every completed call
+type AccessLogLine struct {
+	Time         string  `json:"ts"`
+	Method       string  `json:"method"`
+	Peer         string  `json:"peer"`
+	Principal    string  `json:"principal,omitempty"`
+	Code         string  `json:"code"`
+	DurationMs   float64 `json:"durationMs"`
+	RequestSize  int64   `json:"requestSize"`
+	ResponseSize int64   `json:"responseSize"`
+	RequestID    string  `json:"requestId,omitempty"`
+
+	//Stream calls also count their messages
+	Stream       bool  `json:"stream,omitempty"`
+	MsgsReceived int64 `json:"msgsReceived,omitempty"`
+	MsgsSent     int64 `json:"msgsSent,omitempty"`
+}
+
+//accessLogCall is kept in the call's context while it runs. Messages can be sent and received on different goroutines.
+type accessLogCall struct {
+	start        time.Time
+	requestSize  int64
+	responseSize int64
+	received     int64
+	sent         int64
+	//user is set by the auth middleware when it runs inside the access log, see recordPrincipal
+	user atomic.Value
+}
+
+type accessLogKey struct{}
+
+//NewAccessLog creates middleware that writes one AccessLogLine to w for every completed call.
+//Use a RotatingFile to write to a file that rotates by size and age.
+func NewAccessLog(w io.Writer) Middleware {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+
+	return Middleware{
+		Name: "accesslog",
+		Before: func(ctx context.Context, info *CallInfo) (context.Context, error) {
+			return context.WithValue(ctx, accessLogKey{}, &accessLogCall{start: time.Now()}), nil
+		},
+		OnRecv: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			call := ctx.Value(accessLogKey{}).(*accessLogCall)
+			atomic.AddInt64(&call.received, 1)
+			atomic.AddInt64(&call.requestSize, messageSize(m))
+			return nil
+		},
+		OnSend: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			call := ctx.Value(accessLogKey{}).(*accessLogCall)
+			atomic.AddInt64(&call.sent, 1)
+			atomic.AddInt64(&call.responseSize, messageSize(m))
+			return nil
+		},
+		After: func(ctx context.Context, info *CallInfo, err error) error {
+			call := ctx.Value(accessLogKey{}).(*accessLogCall)
+
+			line := AccessLogLine{
+				Time:         call.start.UTC().Format(time.RFC3339Nano),
+				Method:       info.FullMethod,
+				Peer:         peerAddress(ctx),
+				Principal:    principal(ctx, call),
+				Code:         grpc.Code(err).String(),
+				DurationMs:   float64(time.Since(call.start)) / float64(time.Millisecond),
+				RequestSize:  atomic.LoadInt64(&call.requestSize),
+				ResponseSize: atomic.LoadInt64(&call.responseSize),
+				RequestID:    RequestIDFromContext(ctx),
+			}
+			if info.IsClientStream || info.IsServerStream {
+				line.Stream = true
+				line.MsgsReceived = atomic.LoadInt64(&call.received)
+				line.MsgsSent = atomic.LoadInt64(&call.sent)
+			}
+
+			mu.Lock()
+			enc.Encode(line)
+			mu.Unlock()
+
+			return err
+		},
+	}
+}
+
+//messageSize is the encoded size of a proto message, or 0 for anything else
+func messageSize(m interface{}) int64 {
+	if pm, ok := m.(proto.Message); ok {
+		return int64(proto.Size(pm))
+	}
+	return 0
+}
+
+//recordPrincipal tells the access log running around the call in ctx who the caller is. When the access log runs before auth,
+//as it does so rejected calls are logged too, it never sees the context auth adds the user to.
+func recordPrincipal(ctx context.Context, user []string) {
+	if call, ok := ctx.Value(accessLogKey{}).(*accessLogCall); ok {
+		call.user.Store(user)
+	}
+}
+
+//principal is the user the auth middleware attached, either to ctx or to the call. Credentials that weren't accepted
+//are never logged, only Redacted to show that there were some.
+func principal(ctx context.Context, call *accessLogCall) string {
+	if user := UserFromContext(ctx); user != nil {
+		return strings.Join(user, ",")
+	}
+	if user, ok := call.user.Load().([]string); ok {
+		return strings.Join(user, ",")
+	}
+
+	if md, ok := metadata.FromContext(ctx); ok && len(md[authKey]) > 0 {
+		return Redacted
+	}
+	return ""
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/mwitkow/go-grpc-middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAccessLogPrincipal(t *testing.T) {
+	tests := []struct {
+		name string
+		ctx  context.Context
+		want string
+	}{
+		{"no credentials", context.Background(), ""},
+		{"credentials before auth", metadata.NewContext(context.Background(), metadata.Pairs(authKey, "s3cret")), Redacted},
+		{"user from auth", context.WithValue(context.Background(), userKey{}, []string{"bob"}), "bob"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			callUnary(test.ctx, NewAccessLog(&buf).Unary(), testMethod, nil, nil, grpc.Errorf(codes.NotFound, "no"))
+
+			if strings.Contains(buf.String(), "s3cret") {
+				t.Fatalf("credentials were logged: %s", buf.String())
+			}
+
+			var line AccessLogLine
+			if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+				t.Fatal(err)
+			}
+			if line.Principal != test.want {
+				t.Errorf("principal = %q, want %q", line.Principal, test.want)
+			}
+			if line.Method != testMethod || line.Code != "NotFound" {
+				t.Errorf("line = %+v, want %s NotFound", line, testMethod)
+			}
+		})
+	}
+}
+
+func TestAccessLogStreamCounts(t *testing.T) {
+	var buf bytes.Buffer
+	callStream(context.Background(), NewAccessLog(&buf).Stream(), testMethod, &loginRequest{}, nil)
+
+	var line AccessLogLine
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatal(err)
+	}
+	if !line.Stream || line.MsgsReceived != 1 || line.MsgsSent != 1 || line.Code != "OK" {
+		t.Errorf("line = %+v, want a stream with 1 message each way", line)
+	}
+
+	//the line ends with a newline so the file is JSON lines
+	if !strings.HasSuffix(buf.String(), "}\n") {
+		t.Errorf("line = %q, want it to end in a newline", buf.String())
+	}
+
+	var generic map[string]interface{}
+	json.Unmarshal(buf.Bytes(), &generic)
+	if _, ok := generic["principal"]; ok {
+		t.Errorf("principal is set on a call without credentials: %s", buf.String())
+	}
+}
+
+//TestAccessLogShippedConfig runs calls through the chains in greeter_server/middleware.json, where the access log comes
+//before auth so rejected calls are logged too
+func TestAccessLogShippedConfig(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	b, err := ioutil.ReadFile("../middleware.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ParseConfig(strings.NewReader(strings.Replace(string(b), "greeter_server/access.log", path, -1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	defer applyAccessLogs(nil)
+	unary := grpc_middleware.ChainUnaryServer(cfg.UnaryInterceptors()...)
+	stream := grpc_middleware.ChainStreamServer(cfg.StreamInterceptors()...)
+
+	authed := metadata.NewContext(context.Background(), metadata.Pairs(authKey, "user123"))
+	callUnary(authed, unary, testMethod, nil, nil, nil)
+	callUnary(context.Background(), unary, testMethod, nil, nil, nil)
+	callStream(authed, stream, testMethod, &loginRequest{}, nil)
+
+	var lines []AccessLogLine
+	for _, l := range strings.Split(strings.TrimSpace(readFile(t, path)), "\n") {
+		var line AccessLogLine
+		if err := json.Unmarshal([]byte(l), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+
+	want := []struct {
+		principal string
+		code      string
+		stream    bool
+	}{
+		{"user123", "OK", false},
+		{"", "Unauthenticated", false},
+		{"user123", "OK", true},
+	}
+	if len(lines) != len(want) {
+		t.Fatalf("got %d lines, want %d: %+v", len(lines), len(want), lines)
+	}
+	for i, w := range want {
+		if lines[i].Principal != w.principal || lines[i].Code != w.code || lines[i].Stream != w.stream {
+			t.Errorf("line %d = %+v, want principal %q, code %s, stream %v", i, lines[i], w.principal, w.code, w.stream)
+		}
+	}
+}
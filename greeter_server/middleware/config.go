@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"google.golang.org/grpc"
+)
+
+//Config describes an interceptor chain, usually read from a JSON file like:
+//
+//	{
+//	  "unary": [
+//	    {"name": "deadline", "params": {"duration": "1s"}, "except": ["/helloworld.Greeter/SayHelloSlow"]},
+//	    {"name": "auth"}
+//	  ],
+//	  "stream": [
+//	    {"name": "auth"}
+//	  ]
+//	}
+//
+//Names are looked up in the registry, see RegisterUnary and RegisterStream.
+type Config struct {
+	Unary  []InterceptorConfig `json:"unary"`
+	Stream []InterceptorConfig `json:"stream"`
+
+	unary  []grpc.UnaryServerInterceptor
+	stream []grpc.StreamServerInterceptor
+}
+
+//InterceptorConfig is a single entry in the chain
+type InterceptorConfig struct {
+	Name   string `json:"name"`
+	Params Params `json:"params,omitempty"`
+
+	//Only and Except are MethodFilter patterns
+	Only   []string `json:"only,omitempty"`
+	Except []string `json:"except,omitempty"`
+}
+
+//Filter returns the MethodFilter for the entry
+func (c InterceptorConfig) Filter() MethodFilter {
+	return MethodFilter{Only: c.Only, Except: c.Except}
+}
+
+//LoadConfig reads and builds the config file at path
+func LoadConfig(path string) (*Config, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, err := ParseConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return cfg, nil
+}
+
+//ParseConfig reads a JSON config and builds its interceptors, so a bad name or param is reported here instead of when the server starts.
+//Unknown fields, middleware names and params, and bad only/except patterns, are errors rather than being ignored.
+func ParseConfig(r io.Reader) (*Config, error) {
+	cfg := &Config{}
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(cfg); err != nil {
+		return nil, err
+	}
+
+	for i, c := range cfg.Unary {
+		if err := c.Filter().Validate(); err != nil {
+			return nil, fmt.Errorf("unary[%d] %s: %v", i, c.Name, err)
+		}
+		interceptor, err := NewUnary(c.Name, c.Params)
+		if err != nil {
+			return nil, fmt.Errorf("unary[%d] %s: %v", i, c.Name, err)
+		}
+		cfg.unary = append(cfg.unary, UnaryFor(c.Filter(), interceptor))
+	}
+
+	for i, c := range cfg.Stream {
+		if err := c.Filter().Validate(); err != nil {
+			return nil, fmt.Errorf("stream[%d] %s: %v", i, c.Name, err)
+		}
+		interceptor, err := NewStream(c.Name, c.Params)
+		if err != nil {
+			return nil, fmt.Errorf("stream[%d] %s: %v", i, c.Name, err)
+		}
+		cfg.stream = append(cfg.stream, StreamFor(c.Filter(), interceptor))
+	}
+
+	return cfg, nil
+}
+
+//Apply opens the files the config's access logs write to, applies their rotation limits and closes the files of the config
+//that was applied before that this one doesn't use. Building a config has no side effects, so a config is only put in
+//place once it has been built completely. If Apply fails the previously applied config is left as it was.
+func (c *Config) Apply() error {
+	var targets []accessLogTarget
+	for _, entries := range [][]InterceptorConfig{c.Unary, c.Stream} {
+		for _, e := range entries {
+			if e.Name != "accesslog" {
+				continue
+			}
+			//Already checked when the config was built
+			t, err := accessLogParams(e.Params)
+			if err != nil {
+				return err
+			}
+			targets = append(targets, t)
+		}
+	}
+	return applyAccessLogs(targets)
+}
+
+//UnaryInterceptors returns the unary chain in the order it's listed in the config
+func (c *Config) UnaryInterceptors() []grpc.UnaryServerInterceptor {
+	return c.unary
+}
+
+//StreamInterceptors returns the stream chain in the order it's listed in the config
+func (c *Config) StreamInterceptors() []grpc.StreamServerInterceptor {
+	return c.stream
+}
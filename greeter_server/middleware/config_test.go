@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{
+			name:   "valid",
+			config: `{"unary": [{"name": "deadline", "params": {"duration": "1s"}, "except": ["/helloworld.Greeter/SayHelloSlow"]}, {"name": "auth"}], "stream": [{"name": "auth"}]}`,
+		},
+		{
+			name:    "unknown top level field",
+			config:  `{"unray": [{"name": "auth"}]}`,
+			wantErr: `unknown field "unray"`,
+		},
+		{
+			name:    "unknown entry field",
+			config:  `{"unary": [{"name": "auth", "exept": ["/helloworld.Greeter/SayHello"]}]}`,
+			wantErr: `unknown field "exept"`,
+		},
+		{
+			name:    "unknown name",
+			config:  `{"unary": [{"name": "auht"}]}`,
+			wantErr: `unary[0] auht: unknown unary middleware "auht"`,
+		},
+		{
+			name:    "stream only name",
+			config:  `{"stream": [{"name": "deadline", "params": {"duration": "1s"}}]}`,
+			wantErr: `stream[0] deadline: unknown stream middleware "deadline"`,
+		},
+		{
+			name:    "unknown param",
+			config:  `{"unary": [{"name": "deadline", "params": {"duration": "1s", "durration": "2s"}}]}`,
+			wantErr: `unary[0] deadline: unknown params ["durration"], have ["duration"]`,
+		},
+		{
+			name:    "param for middleware without params",
+			config:  `{"stream": [{"name": "auth"}, {"name": "tracing", "params": {"sample": "0.1"}}]}`,
+			wantErr: `stream[1] tracing: unknown params ["sample"], it doesn't take any`,
+		},
+		{
+			name:    "bad only pattern",
+			config:  `{"unary": [{"name": "auth", "only": ["/helloworld.Greeter/SayHello["]}]}`,
+			wantErr: `unary[0] auth: pattern "/helloworld.Greeter/SayHello[": syntax error in pattern`,
+		},
+		{
+			name:    "bad except pattern",
+			config:  `{"stream": [{"name": "auth", "except": ["/helloworld.Greeter/*", "/helloworld.Greeter/[a-"]}]}`,
+			wantErr: `stream[0] auth: pattern "/helloworld.Greeter/[a-": syntax error in pattern`,
+		},
+		{
+			name:    "unknown logging param",
+			config:  `{"unary": [{"name": "logging", "params": {"payloads": "true", "maxPayloads": "512"}}]}`,
+			wantErr: `unknown params ["maxPayloads"]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg, err := ParseConfig(strings.NewReader(test.config))
+			if test.wantErr == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(cfg.UnaryInterceptors()) != 2 || len(cfg.StreamInterceptors()) != 1 {
+					t.Errorf("got %d unary and %d stream interceptors, want 2 and 1", len(cfg.UnaryInterceptors()), len(cfg.StreamInterceptors()))
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("err = %v, want it to contain %s", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"golang.org/x/net/context"
+)
+
+//InFlight counts the calls running right now for each method and remembers the most that ever ran at once
+type InFlight struct {
+	mu      sync.Mutex
+	methods map[string]*InFlightCount
+
+	current *metrics.GaugeVec
+	peak    *metrics.GaugeVec
+}
+
+//InFlightCount is what InFlight knows about one method
+type InFlightCount struct {
+	FullMethod string `json:"fullMethod"`
+	Current    int64  `json:"current"`
+	Peak       int64  `json:"peak"`
+}
+
+//DefaultInFlight is the tracker used by the default middleware and the "inflight" registry entry
+var DefaultInFlight = NewInFlight(metrics.Default)
+
+//NewInFlight creates an InFlight that also reports its counts to r
+func NewInFlight(r *metrics.Registry) *InFlight {
+	return &InFlight{
+		methods: map[string]*InFlightCount{},
+		current: r.Gauge("grpc_server_inflight", "Number of RPCs running on the server right now, by method.", "method"),
+		peak:    r.Gauge("grpc_server_inflight_peak", "Most RPCs that ran on the server at once, by method.", "method"),
+	}
+}
+
+//Middleware returns middleware that counts calls while they run. Add it once, near the front of the chain, so rejected calls are counted too.
+func (f *InFlight) Middleware() Middleware {
+	return Middleware{
+		Name: "inflight",
+		Before: func(ctx context.Context, info *CallInfo) (context.Context, error) {
+			f.add(info.FullMethod, 1)
+			return ctx, nil
+		},
+		After: func(ctx context.Context, info *CallInfo, err error) error {
+			f.add(info.FullMethod, -1)
+			return err
+		},
+	}
+}
+
+func (f *InFlight) add(fullMethod string, n int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c, ok := f.methods[fullMethod]
+	if !ok {
+		c = &InFlightCount{FullMethod: fullMethod}
+		f.methods[fullMethod] = c
+	}
+
+	c.Current += n
+	f.current.Set(float64(c.Current), fullMethod)
+	if c.Current > c.Peak {
+		c.Peak = c.Current
+		f.peak.Set(float64(c.Peak), fullMethod)
+	}
+}
+
+//Counts returns the counts for every method that has been called, sorted by method
+func (f *InFlight) Counts() []InFlightCount {
+	f.mu.Lock()
+	counts := make([]InFlightCount, 0, len(f.methods))
+	for _, c := range f.methods {
+		counts = append(counts, *c)
+	}
+	f.mu.Unlock()
+
+	sort.Slice(counts, func(i, j int) bool {
+		return counts[i].FullMethod < counts[j].FullMethod
+	})
+	return counts
+}
+
+//ResetPeaks sets each method's peak to the number of calls running now, to start measuring a new window
+func (f *InFlight) ResetPeaks() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for method, c := range f.methods {
+		c.Peak = c.Current
+		f.peak.Set(float64(c.Peak), method)
+	}
+}
+
+//Handler serves the counts as text, or as JSON with ?format=json. ?method= limits it to methods matching a MethodFilter pattern
+//and a POST with ?reset=true resets the peaks after they're written.
+func (f *InFlight) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		counts := f.Counts()
+
+		if pattern := r.URL.Query().Get("method"); pattern != "" {
+			filter := OnlyMethods(pattern)
+
+			matched := []InFlightCount{}
+			for _, c := range counts {
+				if filter.Match(c.FullMethod) {
+					matched = append(matched, c)
+				}
+			}
+			counts = matched
+		}
+
+		if r.Method == http.MethodPost && r.URL.Query().Get("reset") == "true" {
+			f.ResetPeaks()
+		}
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(counts)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, c := range counts {
+			fmt.Fprintf(w, "%s current=%d peak=%d\n", c.FullMethod, c.Current, c.Peak)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func counts(f *InFlight, fullMethod string) InFlightCount {
+	for _, c := range f.Counts() {
+		if c.FullMethod == fullMethod {
+			return c
+		}
+	}
+	return InFlightCount{}
+}
+
+func TestInFlightCurrentAndPeak(t *testing.T) {
+	r := metrics.NewRegistry()
+	f := NewInFlight(r)
+	interceptor := f.Middleware().Unary()
+
+	const calls = 5
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < calls; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			interceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+				<-release
+				return nil, nil
+			})
+		}()
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for counts(f, testMethod).Current != calls {
+		if time.Now().After(deadline) {
+			t.Fatalf("counts = %+v, want %d calls running", f.Counts(), calls)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if s, _ := series(r, "grpc_server_inflight", testMethod); s.Value != calls {
+		t.Errorf("grpc_server_inflight = %v, want %d", s.Value, calls)
+	}
+
+	close(release)
+	wg.Wait()
+
+	want := InFlightCount{FullMethod: testMethod, Current: 0, Peak: calls}
+	if got := counts(f, testMethod); got != want {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+	if s, _ := series(r, "grpc_server_inflight", testMethod); s.Value != 0 {
+		t.Errorf("grpc_server_inflight = %v, want 0", s.Value)
+	}
+	if s, _ := series(r, "grpc_server_inflight_peak", testMethod); s.Value != calls {
+		t.Errorf("grpc_server_inflight_peak = %v, want %d", s.Value, calls)
+	}
+}
+
+func TestInFlightErrors(t *testing.T) {
+	f := NewInFlight(metrics.NewRegistry())
+
+	//a call the handler fails
+	err := callUnary(context.Background(), f.Middleware().Unary(), testMethod, nil, nil, grpc.Errorf(codes.Internal, "broken"))
+	if grpc.Code(err) != codes.Internal {
+		t.Fatalf("err = %v, want Internal", err)
+	}
+
+	//a call rejected by middleware after the counter, before it gets to the handler
+	reject := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return nil, grpc.Errorf(codes.Unauthenticated, "no credentials")
+	}
+	err = callUnary(context.Background(), chainUnary(f.Middleware().Unary(), reject), testMethod, nil, nil, nil)
+	if grpc.Code(err) != codes.Unauthenticated {
+		t.Fatalf("err = %v, want Unauthenticated", err)
+	}
+
+	//a stream that fails
+	err = callStream(context.Background(), f.Middleware().Stream(), streamMethod, nil, grpc.Errorf(codes.Canceled, "gone"))
+	if grpc.Code(err) != codes.Canceled {
+		t.Fatalf("err = %v, want Canceled", err)
+	}
+
+	want := []InFlightCount{
+		{FullMethod: testMethod, Current: 0, Peak: 1},
+		{FullMethod: streamMethod, Current: 0, Peak: 1},
+	}
+	if got := f.Counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("counts = %+v, want %+v", got, want)
+	}
+}
+
+func TestInFlightHandler(t *testing.T) {
+	f := NewInFlight(metrics.NewRegistry())
+	f.add(testMethod, 3)
+	f.add(testMethod, -2)
+	f.add(streamMethod, 1)
+
+	serve := func(method, query string) string {
+		w := httptest.NewRecorder()
+		f.Handler().ServeHTTP(w, httptest.NewRequest(method, "/debug/inflight?"+query, nil))
+		return w.Body.String()
+	}
+
+	want := testMethod + " current=1 peak=3\n" + streamMethod + " current=1 peak=1\n"
+	if got := serve("GET", ""); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	var got []InFlightCount
+	if err := json.Unmarshal([]byte(serve("GET", "format=json&method=/helloworld.Greeter/SayHelloTo*")), &got); err != nil {
+		t.Fatal(err)
+	}
+	if want := []InFlightCount{{FullMethod: streamMethod, Current: 1, Peak: 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	//a GET never resets the peaks
+	serve("GET", "reset=true")
+	if c := counts(f, testMethod); c.Peak != 3 {
+		t.Errorf("peak = %d after a GET, want 3", c.Peak)
+	}
+
+	//the response to the reset still has the old peaks, the next one starts from the calls running now
+	if got, want := serve("POST", "reset=true&method="+testMethod), testMethod+" current=1 peak=3\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	want = testMethod + " current=1 peak=1\n" + streamMethod + " current=1 peak=1\n"
+	if got := serve("GET", ""); got != want {
+		t.Errorf("after reset got %q, want %q", got, want)
+	}
+}
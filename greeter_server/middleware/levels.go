@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+)
+
+//CodeLevels picks the level a finished call is logged at from its status code
+type CodeLevels func(code codes.Code) Level
+
+//DefaultCodeLevels logs OK calls at Info, errors caused by the client at Warn and errors caused by the server at Error
+func DefaultCodeLevels(code codes.Code) Level {
+	switch code {
+	case codes.OK:
+		return LevelInfo
+	case codes.Canceled,
+		codes.InvalidArgument,
+		codes.NotFound,
+		codes.AlreadyExists,
+		codes.PermissionDenied,
+		codes.Unauthenticated,
+		codes.ResourceExhausted,
+		codes.FailedPrecondition,
+		codes.Aborted,
+		codes.OutOfRange,
+		codes.DeadlineExceeded:
+		return LevelWarn
+	default:
+		//Unknown, Internal, Unimplemented, Unavailable, DataLoss
+		return LevelError
+	}
+}
+
+type methodCodeLevels struct {
+	filter MethodFilter
+	levels CodeLevels
+}
+
+//WithCodeLevels replaces DefaultCodeLevels for every method
+func WithCodeLevels(levels CodeLevels) LoggingOption {
+	return func(o *loggingOptions) {
+		o.defaultLevels = levels
+	}
+}
+
+//WithMethodCodeLevels uses levels for the methods matched by the filter.
+//When more than one filter matches a method the one added first wins.
+func WithMethodCodeLevels(f MethodFilter, levels CodeLevels) LoggingOption {
+	return func(o *loggingOptions) {
+		o.methodLevels = append(o.methodLevels, methodCodeLevels{filter: f, levels: levels})
+	}
+}
+
+//level returns the level a call to fullMethod that finished with code is logged at
+func (o loggingOptions) level(fullMethod string, code codes.Code) Level {
+	for _, m := range o.methodLevels {
+		if m.filter.Match(fullMethod) {
+			return m.levels(code)
+		}
+	}
+
+	if o.defaultLevels != nil {
+		return o.defaultLevels(code)
+	}
+	return DefaultCodeLevels(code)
+}
+
+//log writes to the logger at level
+func (o loggingOptions) log(level Level, ctx context.Context, msg string, fields ...Field) {
+	switch level {
+	case LevelWarn:
+		o.logger.Warn(ctx, msg, fields...)
+	case LevelError:
+		o.logger.Error(ctx, msg, fields...)
+	default:
+		o.logger.Info(ctx, msg, fields...)
+	}
+}
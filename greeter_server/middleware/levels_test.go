@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestLoggingLevels(t *testing.T) {
+	quiet := func(codes.Code) Level { return LevelInfo }
+	loud := func(codes.Code) Level { return LevelError }
+
+	tests := []struct {
+		name   string
+		opts   []LoggingOption
+		method string
+		err    error
+		want   Level
+	}{
+		{"ok", nil, testMethod, nil, LevelInfo},
+		{"client error", nil, testMethod, grpc.Errorf(codes.NotFound, "no"), LevelWarn},
+		{"deadline", nil, testMethod, grpc.Errorf(codes.DeadlineExceeded, "slow"), LevelWarn},
+		{"server error", nil, testMethod, grpc.Errorf(codes.Internal, "broke"), LevelError},
+		{"plain error is unknown", nil, testMethod, errors.New("broke"), LevelError},
+		{"replaced default", []LoggingOption{WithCodeLevels(loud)}, testMethod, nil, LevelError},
+		{
+			name:   "method override",
+			opts:   []LoggingOption{WithMethodCodeLevels(OnlyMethods("/helloworld.Greeter/SayHello*"), quiet)},
+			method: "/helloworld.Greeter/SayHelloSlow",
+			err:    grpc.Errorf(codes.Internal, "broke"),
+			want:   LevelInfo,
+		},
+		{
+			name:   "override doesn't match",
+			opts:   []LoggingOption{WithMethodCodeLevels(OnlyMethods("/other.Service/*"), quiet)},
+			method: testMethod,
+			err:    grpc.Errorf(codes.Internal, "broke"),
+			want:   LevelError,
+		},
+		{
+			name: "first matching override wins",
+			opts: []LoggingOption{
+				WithMethodCodeLevels(OnlyMethods(testMethod), loud),
+				WithMethodCodeLevels(OnlyMethods("/helloworld.Greeter/*"), quiet),
+			},
+			method: testMethod,
+			want:   LevelError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, kind := range []string{"unary", "stream"} {
+				rec := NewRecorder()
+				if kind == "unary" {
+					callUnary(context.Background(), NewUnaryLogging(rec, test.opts...), test.method, &loginRequest{}, &loginRequest{}, test.err)
+				} else {
+					callStream(context.Background(), NewStreamLogging(rec, test.opts...), test.method, &loginRequest{}, test.err)
+				}
+
+				got := finished(rec.Entries())
+				if len(got) != 1 {
+					t.Fatalf("%s: got %d finished lines, want 1: %v", kind, len(got), rec.Entries())
+				}
+				if got[0].Level != test.want {
+					t.Errorf("%s: level = %s, want %s", kind, got[0].Level, test.want)
+				}
+				if got[0].Field("code") != grpc.Code(test.err).String() {
+					t.Errorf("%s: code = %s, want %s", kind, got[0].Field("code"), grpc.Code(test.err))
+				}
+			}
+		})
+	}
+}
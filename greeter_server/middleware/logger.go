@@ -0,0 +1,146 @@
+package middleware
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+
+	"golang.org/x/net/context"
+)
+
+//Logger is what the logging middleware writes to
+type Logger interface {
+	Info(ctx context.Context, msg string, fields ...Field)
+	Warn(ctx context.Context, msg string, fields ...Field)
+	Error(ctx context.Context, msg string, fields ...Field)
+}
+
+//Field is a key/value pair added to a log line
+type Field struct {
+	Key   string
+	Value string
+}
+
+//Level is the severity of a log line
+type Level int
+
+const (
+	LevelInfo Level = iota
+	LevelWarn
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelInfo:
+		return "INFO"
+	case LevelWarn:
+		return "WARN"
+	case LevelError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("Level(%d)", int(l))
+}
+
+//DefaultLogger is used by UnaryLogging, StreamLogging and the logging middleware built from config files
+var DefaultLogger Logger = StdLogger(log.New(os.Stderr, "", log.LstdFlags))
+
+//StdLogger adapts a standard library *log.Logger. Lines look like: INFO msg key=value key=value
+func StdLogger(l *log.Logger) Logger {
+	return stdLogger{l}
+}
+
+type stdLogger struct {
+	l *log.Logger
+}
+
+func (s stdLogger) Info(ctx context.Context, msg string, fields ...Field) {
+	s.print(LevelInfo, msg, fields)
+}
+
+func (s stdLogger) Warn(ctx context.Context, msg string, fields ...Field) {
+	s.print(LevelWarn, msg, fields)
+}
+
+func (s stdLogger) Error(ctx context.Context, msg string, fields ...Field) {
+	s.print(LevelError, msg, fields)
+}
+
+func (s stdLogger) print(level Level, msg string, fields []Field) {
+	var b bytes.Buffer
+	b.WriteString(level.String())
+	if msg != "" {
+		b.WriteByte(' ')
+		b.WriteString(msg)
+	}
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%q", f.Key, f.Value)
+	}
+	s.l.Output(3, b.String())
+}
+
+//Entry is a log line kept by a Recorder
+type Entry struct {
+	Level  Level
+	Msg    string
+	Fields []Field
+}
+
+//Field returns the value of the field with key, or "" if the entry doesn't have it
+func (e Entry) Field(key string) string {
+	for _, f := range e.Fields {
+		if f.Key == key {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+//Recorder is a Logger that keeps log lines in memory so tests can check what was logged
+type Recorder struct {
+	mu      sync.Mutex
+	entries []Entry
+}
+
+//NewRecorder creates an empty Recorder
+func NewRecorder() *Recorder {
+	return &Recorder{}
+}
+
+func (r *Recorder) Info(ctx context.Context, msg string, fields ...Field) {
+	r.record(LevelInfo, msg, fields)
+}
+
+func (r *Recorder) Warn(ctx context.Context, msg string, fields ...Field) {
+	r.record(LevelWarn, msg, fields)
+}
+
+func (r *Recorder) Error(ctx context.Context, msg string, fields ...Field) {
+	r.record(LevelError, msg, fields)
+}
+
+func (r *Recorder) record(level Level, msg string, fields []Field) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, Entry{
+		Level:  level,
+		Msg:    msg,
+		Fields: append([]Field(nil), fields...),
+	})
+}
+
+//Entries returns a copy of everything logged so far
+func (r *Recorder) Entries() []Entry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Entry(nil), r.entries...)
+}
+
+//Reset forgets everything logged so far
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = nil
+}
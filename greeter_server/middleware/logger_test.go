@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"bytes"
+	"log"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestStdLogger(t *testing.T) {
+	var buf bytes.Buffer
+	l := StdLogger(log.New(&buf, "", 0))
+
+	l.Info(context.Background(), "stream started", Field{"FullMethod", testMethod}, Field{"peer", ""})
+	l.Warn(context.Background(), "", Field{"error", `bad "name"`})
+	l.Error(context.Background(), "broken")
+
+	want := `INFO stream started FullMethod="/helloworld.Greeter/SayHello" peer=""
+WARN error="bad \"name\""
+ERROR broken
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLoggingUsesInjectedLogger(t *testing.T) {
+	rec := NewRecorder()
+	callUnary(context.Background(), NewUnaryLogging(rec), testMethod, nil, nil, nil)
+	callStream(context.Background(), NewStreamLogging(rec, LogMessages()), testMethod, nil, nil)
+
+	var msgs []string
+	for _, e := range rec.Entries() {
+		msgs = append(msgs, e.Msg)
+	}
+	want := []string{"", "", "stream started", "stream message received", "stream message sent", "stream finished"}
+	if len(msgs) != len(want) {
+		t.Fatalf("logged %q, want %q", msgs, want)
+	}
+	for i := range want {
+		if msgs[i] != want[i] {
+			t.Errorf("line %d = %q, want %q", i, msgs[i], want[i])
+		}
+	}
+}
+
+func TestLoggingUsesDefaultLogger(t *testing.T) {
+	rec := NewRecorder()
+	old := DefaultLogger
+	DefaultLogger = rec
+	defer func() { DefaultLogger = old }()
+
+	callUnary(context.Background(), UnaryLogging, testMethod, nil, nil, nil)
+	callStream(context.Background(), StreamLogging, testMethod, nil, nil)
+
+	//the start and end of each call, and no stream messages unless they're asked for
+	if got := len(rec.Entries()); got != 4 {
+		t.Errorf("DefaultLogger got %d lines, want 4: %+v", got, rec.Entries())
+	}
+	if got := len(finished(rec.Entries())); got != 2 {
+		t.Errorf("DefaultLogger got %d finished calls, want 2", got)
+	}
+}
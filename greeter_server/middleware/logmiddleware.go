@@ -2,85 +2,220 @@ package middleware
 
 import (
 	"fmt"
+	"strconv"
+	"sync/atomic"
 	"time"
 
-	"github.com/weave-lab/wlib/wlog"
-	"github.com/weave-lab/wlib/wlog/tag"
-
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
 )
 
-var Logger = wlog.NewWLogger(wlog.WlogdLogger)
+//LoggingOption configures the logging middleware
+type LoggingOption func(*loggingOptions)
+
+type loggingOptions struct {
+	logger        Logger
+	messages      bool
+	payloads      payloadOptions
+	sampler       *sampler
+	defaultLevels CodeLevels
+	methodLevels  []methodCodeLevels
+}
 
-//UnaryLogging for handling logging for unary gRPC endpoints
+func newLoggingOptions(logger Logger, opts []LoggingOption) loggingOptions {
+	o := loggingOptions{logger: logger}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	o.sampler.setLogger(logger)
+	return o
+}
+
+//LogMessages logs every message sent and received on a stream, not just the start and end of it
+func LogMessages() LoggingOption {
+	return func(o *loggingOptions) {
+		o.messages = true
+	}
+}
+
+//UnaryLogging for handling logging for unary gRPC endpoints. Logs to DefaultLogger.
 func UnaryLogging(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	return logUnary(loggingOptions{logger: DefaultLogger}, ctx, req, info, handler)
+}
+
+//NewUnaryLogging creates a UnaryLogging interceptor that logs to logger
+func NewUnaryLogging(logger Logger, opts ...LoggingOption) grpc.UnaryServerInterceptor {
+	o := newLoggingOptions(logger, opts)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		return logUnary(o, ctx, req, info, handler)
+	}
+}
+
+func logUnary(o loggingOptions, ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
 	start := time.Now()
-	//TODO get request id
+	o.logger = withTraceIDs(o.logger)
+	requestID := RequestIDFromContext(ctx)
+
+	sampled := o.sampler.sampleCall(info.FullMethod)
 
 	//What info can we and should we log here
-	Logger.InfoC(
-		ctx,
-		"",
-		tag.String("FullMethod", info.FullMethod),
-		tag.String("t", time.Now().String()))
+	fields := []Field{
+		{"requestID", requestID},
+		{"FullMethod", info.FullMethod},
+		{"t", time.Now().String()},
+	}
+	if o.payloads.enabled {
+		fields = append(fields, Field{"request", o.payloads.format(req)})
+	}
+	if o.sampler.allowLine(info.FullMethod, sampled, false) {
+		o.logger.Info(ctx, "", fields...)
+	}
 
 	resp, err = handler(ctx, req)
 
-	Logger.InfoC(
-		ctx,
-		"",
-		tag.String("t", time.Now().String()),
-		tag.String("duration", time.Since(start).String()))
+	//Log at a level that depends on how the call went
+	code := grpc.Code(err)
+	fields = []Field{
+		{"requestID", requestID},
+		{"FullMethod", info.FullMethod},
+		{"t", time.Now().String()},
+		{"duration", time.Since(start).String()},
+		{"code", code.String()},
+		{"error", errString(err)},
+	}
+	if o.payloads.enabled && err == nil {
+		fields = append(fields, Field{"response", o.payloads.format(resp)})
+	}
+	if o.sampler.allowLine(info.FullMethod, sampled, err != nil) {
+		o.log(o.level(info.FullMethod, code), ctx, "", fields...)
+	}
 
 	return resp, err
 }
 
-//StreamLogging for handling logging for unary gRPC endpoints
+//StreamLogging for handling logging for streaming gRPC endpoints. Only the start and end of the stream are logged, to DefaultLogger.
 func StreamLogging(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return logStream(loggingOptions{logger: DefaultLogger}, srv, ss, info, handler)
+}
 
-	//Do logging before streaming starts
-	fmt.Println("Log: Calling", info.FullMethod)
+//NewStreamLogging creates a StreamLogging interceptor that logs to logger
+func NewStreamLogging(logger Logger, opts ...LoggingOption) grpc.StreamServerInterceptor {
+	o := newLoggingOptions(logger, opts)
 
-	newStream := wrapServerStream(ss)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return logStream(o, srv, ss, info, handler)
+	}
+}
 
-	newStream.RegisterRecvMiddleware(func(inner StreamHandler) StreamHandler {
+func logStream(o loggingOptions, srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	start := time.Now()
+	o.logger = withTraceIDs(o.logger)
+	ctx := ss.Context()
+	requestID := RequestIDFromContext(ctx)
+	peerAddr := peerAddress(ctx)
 
-		//Log when messages are received
-		mw := func(m interface{}) error {
-			fmt.Printf("Log: Receiving msg from stream: %v\n", m)
+	sampled := o.sampler.sampleCall(info.FullMethod)
 
-			err := inner.Stream(m)
+	//Do logging before streaming starts
+	if o.sampler.allowLine(info.FullMethod, sampled, false) {
+		o.logger.Info(
+			ctx,
+			"stream started",
+			Field{"requestID", requestID},
+			Field{"FullMethod", info.FullMethod},
+			Field{"peer", peerAddr},
+			Field{"start", start.String()})
+	}
+
+	//Sending and receiving can happen on different goroutines
+	var sent, received int64
 
-			fmt.Printf("Log: Received msg from stream: %v\n", m)
-			return err
-		}
+	newStream := wrapServerStream(ss)
 
-		return StreamFunc(mw)
+	newStream.RegisterRecvMiddleware(func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(m interface{}) error {
+			err := inner.Stream(m)
+			if err != nil {
+				return err
+			}
+
+			n := atomic.AddInt64(&received, 1)
+			if (o.messages || o.payloads.enabled) && o.sampler.allowLine(info.FullMethod, sampled, false) {
+				o.logMessage(ctx, "stream message received", requestID, info.FullMethod, m, n)
+			}
+			return nil
+		})
 	})
 
 	newStream.RegisterSendMiddleware(func(inner StreamHandler) StreamHandler {
-		//Log when messages are sent
-		mw := func(m interface{}) error {
-			fmt.Printf("Log: Sending msg to stream: %v\n", m)
-
+		return StreamFunc(func(m interface{}) error {
 			err := inner.Stream(m)
-
-			fmt.Printf("Log: Sent msg to stream: %v\n", m)
-			return err
-		}
-
-		return StreamFunc(mw)
+			if err != nil {
+				return err
+			}
+
+			n := atomic.AddInt64(&sent, 1)
+			if (o.messages || o.payloads.enabled) && o.sampler.allowLine(info.FullMethod, sampled, false) {
+				o.logMessage(ctx, "stream message sent", requestID, info.FullMethod, m, n)
+			}
+			return nil
+		})
 	})
 
 	err := handler(srv, newStream)
 
 	//Do logging after streaming finishes
-	fmt.Println("Log:", info.FullMethod, "completed.")
-
-	if err != nil {
+	end := time.Now()
+	code := grpc.Code(err)
+	if !o.sampler.allowLine(info.FullMethod, sampled, err != nil) {
 		return err
 	}
+	o.log(
+		o.level(info.FullMethod, code),
+		ctx,
+		"stream finished",
+		Field{"requestID", requestID},
+		Field{"FullMethod", info.FullMethod},
+		Field{"peer", peerAddr},
+		Field{"start", start.String()},
+		Field{"end", end.String()},
+		Field{"duration", end.Sub(start).String()},
+		Field{"sent", strconv.FormatInt(atomic.LoadInt64(&sent), 10)},
+		Field{"received", strconv.FormatInt(atomic.LoadInt64(&received), 10)},
+		Field{"code", code.String()},
+		Field{"error", errString(err)})
+
+	return err
+}
 
-	return nil
+//logMessage logs a single message on a stream, the nth one sent or received
+func (o loggingOptions) logMessage(ctx context.Context, msg, requestID, fullMethod string, m interface{}, n int64) {
+	fields := []Field{
+		{"requestID", requestID},
+		{"FullMethod", fullMethod},
+		{"type", fmt.Sprintf("%T", m)},
+		{"n", strconv.FormatInt(n, 10)},
+	}
+	if o.payloads.enabled {
+		fields = append(fields, Field{"payload", o.payloads.format(m)})
+	}
+	o.logger.Info(ctx, msg, fields...)
+}
+
+//peerAddress returns the address of the client that made the call
+func peerAddress(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.String()
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
 }
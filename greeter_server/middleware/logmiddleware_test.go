@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+const testMethod = "/helloworld.Greeter/SayHello"
+
+type account struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+type loginRequest struct {
+	Name     string    `json:"name"`
+	Password string    `json:"password,omitempty"`
+	User     *account  `json:"user,omitempty"`
+	Users    []account `json:"users,omitempty"`
+}
+
+//callUnary runs a unary call through interceptor with a handler that returns resp and handlerErr
+func callUnary(ctx context.Context, interceptor grpc.UnaryServerInterceptor, method string, req, resp interface{}, handlerErr error) error {
+	info := &grpc.UnaryServerInfo{FullMethod: method}
+	_, err := interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return resp, handlerErr
+	})
+	return err
+}
+
+//callStream runs a stream through interceptor with a handler that receives one message, sends send and returns handlerErr
+func callStream(ctx context.Context, interceptor grpc.StreamServerInterceptor, method string, send interface{}, handlerErr error) error {
+	info := &grpc.StreamServerInfo{FullMethod: method, IsClientStream: true, IsServerStream: true}
+	return interceptor(nil, &fakeServerStream{ctx: ctx}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		var m loginRequest
+		if err := ss.RecvMsg(&m); err != nil {
+			return err
+		}
+		if err := ss.SendMsg(send); err != nil {
+			return err
+		}
+		return handlerErr
+	})
+}
+
+//finished returns the entries that log the end of a call
+func finished(entries []Entry) []Entry {
+	var out []Entry
+	for _, e := range entries {
+		if e.Field("code") != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+//chainUnary runs outer then inner, like grpc_middleware.ChainUnaryServer
+func chainUnary(outer, inner grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return outer(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+			return inner(ctx, req, info, handler)
+		})
+	}
+}
+
+//chainStream runs outer then inner, like grpc_middleware.ChainStreamServer
+func chainStream(outer, inner grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return outer(srv, ss, info, func(srv interface{}, ss grpc.ServerStream) error {
+			return inner(srv, ss, info, handler)
+		})
+	}
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"fmt"
+	"path"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+//MethodFilter picks which methods a middleware applies to by matching info.FullMethod against patterns such as "/helloworld.Greeter/SayHello*".
+//Patterns use path.Match syntax. The zero value matches every method.
+type MethodFilter struct {
+	//Only limits the middleware to methods matching one of these patterns. Empty means all methods.
+	Only []string
+	//Except skips methods matching one of these patterns, even if they are in Only.
+	Except []string
+}
+
+//OnlyMethods returns a filter that matches only the methods matching one of the patterns
+func OnlyMethods(patterns ...string) MethodFilter {
+	return MethodFilter{Only: patterns}
+}
+
+//ExceptMethods returns a filter that matches every method except the ones matching one of the patterns
+func ExceptMethods(patterns ...string) MethodFilter {
+	return MethodFilter{Except: patterns}
+}
+
+//Match reports whether the middleware should run for fullMethod
+func (f MethodFilter) Match(fullMethod string) bool {
+	if len(f.Only) > 0 && !matchAny(f.Only, fullMethod) {
+		return false
+	}
+
+	return !matchAny(f.Except, fullMethod)
+}
+
+//Validate reports the first bad pattern in the filter. Match treats a bad pattern as never matching, so a typo in
+//Except would silently run the middleware everywhere.
+func (f MethodFilter) Validate() error {
+	for _, patterns := range [][]string{f.Only, f.Except} {
+		for _, p := range patterns {
+			if _, err := path.Match(p, ""); err != nil {
+				return fmt.Errorf("pattern %q: %v", p, err)
+			}
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, fullMethod string) bool {
+	for _, p := range patterns {
+		//Bad patterns are treated as not matching
+		if ok, _ := path.Match(p, fullMethod); ok {
+			return true
+		}
+	}
+	return false
+}
+
+//UnaryFor only runs the interceptor for methods matched by the filter
+func UnaryFor(f MethodFilter, interceptor grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if !f.Match(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		return interceptor(ctx, req, info, handler)
+	}
+}
+
+//StreamFor only runs the interceptor for methods matched by the filter
+func StreamFor(f MethodFilter, interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !f.Match(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		return interceptor(srv, ss, info, handler)
+	}
+}
+
+//For returns a copy of the middleware that only runs for methods matched by the filter
+func (mw Middleware) For(f MethodFilter) Middleware {
+	mw.Methods = f
+	return mw
+}
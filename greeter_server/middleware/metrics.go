@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+//DefaultMetricsSink, if it's set, gets every call UnaryMetrics and StreamMetrics record on top of metrics.Default.
+//Set it to a metrics.StatsD to send them to a StatsD agent.
+var DefaultMetricsSink metrics.Sink
+
+//serverMetrics are the call counters and latency histograms, labeled by type (unary or stream), method and status code
+type serverMetrics struct {
+	handled  *metrics.CounterVec
+	duration *metrics.HistogramVec
+
+	sinks []metrics.Sink
+	//defaultSink also sends to DefaultMetricsSink. It's looked up on every call so it can be set after the interceptors are created.
+	defaultSink bool
+}
+
+func newServerMetrics(r *metrics.Registry, sinks []metrics.Sink, defaultSink bool) serverMetrics {
+	return serverMetrics{
+		handled:     r.Counter("grpc_server_handled_total", "Number of RPCs completed on the server, by method and status code.", "type", "method", "code"),
+		duration:    r.Histogram("grpc_server_handling_seconds", "How long RPCs took to complete on the server, by method and status code.", metrics.DefBuckets, "type", "method", "code"),
+		sinks:       sinks,
+		defaultSink: defaultSink,
+	}
+}
+
+func (m serverMetrics) observe(callType, fullMethod string, start time.Time, err error) {
+	took := time.Since(start)
+	code := grpc.Code(err).String()
+	m.handled.Inc(callType, fullMethod, code)
+	m.duration.Observe(took.Seconds(), callType, fullMethod, code)
+
+	m.eachSink(func(sink metrics.Sink) {
+		tags := []metrics.Tag{{Key: "type", Value: callType}, {Key: "method", Value: fullMethod}, {Key: "code", Value: code}}
+		sink.Count("grpc.server.handled", 1, tags...)
+		sink.Timing("grpc.server.handling", took, tags...)
+	})
+}
+
+func (m serverMetrics) eachSink(f func(metrics.Sink)) {
+	for _, sink := range m.sinks {
+		f(sink)
+	}
+	if m.defaultSink && DefaultMetricsSink != nil {
+		f(DefaultMetricsSink)
+	}
+}
+
+//UnaryMetrics records unary calls to metrics.Default and DefaultMetricsSink
+func UnaryMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	return unaryMetrics(ctx, req, info, handler)
+}
+
+var unaryMetrics = newUnaryMetrics(newServerMetrics(metrics.Default, nil, true))
+
+//NewUnaryMetrics records the number of unary calls and how long they took to r and the sinks
+func NewUnaryMetrics(r *metrics.Registry, sinks ...metrics.Sink) grpc.UnaryServerInterceptor {
+	return newUnaryMetrics(newServerMetrics(r, sinks, false))
+}
+
+func newUnaryMetrics(m serverMetrics) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		now := time.Now()
+
+		resp, err = handler(ctx, req)
+
+		m.observe("unary", info.FullMethod, now, err)
+		return resp, err
+	}
+}
+
+//StreamMetrics records streaming calls to metrics.Default and DefaultMetricsSink
+func StreamMetrics(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return streamMetrics(srv, ss, info, handler)
+}
+
+var streamMetrics = newStreamMetrics(metrics.Default, newServerMetrics(metrics.Default, nil, true))
+
+//streamMessageMetrics are the per-message metrics for streaming calls, labeled by method
+type streamMessageMetrics struct {
+	messages *metrics.CounterVec
+	interval *metrics.HistogramVec
+	open     *metrics.GaugeVec
+}
+
+func newStreamMessageMetrics(r *metrics.Registry) streamMessageMetrics {
+	return streamMessageMetrics{
+		messages: r.Counter("grpc_server_stream_msg_total", "Number of stream messages received and sent on the server, by method and direction.", "method", "direction"),
+		interval: r.Histogram("grpc_server_stream_msg_interval_seconds", "Time between consecutive stream messages in the same direction, by method and direction.", metrics.DefBuckets, "method", "direction"),
+		open:     r.Gauge("grpc_server_streams_open", "Number of streams currently open on the server, by method.", "method"),
+	}
+}
+
+//hook counts the messages going one direction through the stream and times the gap between them.
+//gRPC doesn't allow concurrent calls to RecvMsg or to SendMsg, so last doesn't need a lock.
+func (m streamMessageMetrics) hook(calls serverMetrics, fullMethod, direction string) func(StreamHandler) StreamHandler {
+	var last time.Time
+
+	return func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(msg interface{}) error {
+			err := inner.Stream(msg)
+			if err != nil {
+				return err
+			}
+
+			now := time.Now()
+			m.messages.Inc(fullMethod, direction)
+			calls.eachSink(func(sink metrics.Sink) {
+				sink.Count("grpc.server.stream_msg", 1, metrics.Tag{Key: "method", Value: fullMethod}, metrics.Tag{Key: "direction", Value: direction})
+			})
+			if !last.IsZero() {
+				m.interval.Observe(now.Sub(last).Seconds(), fullMethod, direction)
+			}
+			last = now
+			return nil
+		})
+	}
+}
+
+//NewStreamMetrics records streaming calls to r: the number of streams and how long they were open (the "stream" type of
+//grpc_server_handled_total and grpc_server_handling_seconds), the messages received and sent, the time between messages
+//and how many streams are open right now. The sinks get the number of streams, how long they were open and the message counts.
+func NewStreamMetrics(r *metrics.Registry, sinks ...metrics.Sink) grpc.StreamServerInterceptor {
+	return newStreamMetrics(r, newServerMetrics(r, sinks, false))
+}
+
+func newStreamMetrics(r *metrics.Registry, m serverMetrics) grpc.StreamServerInterceptor {
+	sm := newStreamMessageMetrics(r)
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		now := time.Now()
+
+		sm.open.Inc(info.FullMethod)
+		defer sm.open.Dec(info.FullMethod)
+
+		newStream := wrapServerStream(ss)
+		newStream.RegisterRecvMiddleware(sm.hook(m, info.FullMethod, "received"))
+		newStream.RegisterSendMiddleware(sm.hook(m, info.FullMethod, "sent"))
+
+		err := handler(srv, newStream)
+
+		m.observe("stream", info.FullMethod, now, err)
+		return err
+	}
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+const streamMethod = "/helloworld.Greeter/SayHelloToMany"
+
+//series returns the series of the metric called name with labelValues, or false if there isn't one
+func series(r *metrics.Registry, name string, labelValues ...string) (metrics.Series, bool) {
+	for _, f := range r.Snapshot() {
+		if f.Name != name {
+			continue
+		}
+	next:
+		for _, s := range f.Series {
+			if len(s.LabelValues) != len(labelValues) {
+				continue
+			}
+			for i := range labelValues {
+				if s.LabelValues[i] != labelValues[i] {
+					continue next
+				}
+			}
+			return s, true
+		}
+	}
+	return metrics.Series{}, false
+}
+
+//countingSink is a metrics.Sink that adds up the counts it's given by name
+type countingSink struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (s *countingSink) Count(name string, n int64, tags ...metrics.Tag) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = map[string]int64{}
+	}
+	s.counts[name] += n
+}
+
+func (s *countingSink) Timing(name string, d time.Duration, tags ...metrics.Tag) {}
+
+//endingStream is a fakeServerStream whose RecvMsg fails with recvErr after recv messages
+type endingStream struct {
+	fakeServerStream
+	recv    int
+	recvErr error
+}
+
+func (e *endingStream) RecvMsg(m interface{}) error {
+	if e.recv == 0 {
+		return e.recvErr
+	}
+	e.recv--
+	return e.fakeServerStream.RecvMsg(m)
+}
+
+func TestStreamMetricsMessages(t *testing.T) {
+	r := metrics.NewRegistry()
+	sink := &countingSink{}
+	interceptor := NewStreamMetrics(r, sink)
+
+	ss := &endingStream{recv: 3, recvErr: io.EOF}
+	info := &grpc.StreamServerInfo{FullMethod: streamMethod, IsClientStream: true, IsServerStream: true}
+	err := interceptor(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error {
+		//the stream is open while the handler runs
+		if s, _ := series(r, "grpc_server_streams_open", streamMethod); s.Value != 1 {
+			t.Errorf("open streams = %v during the call, want 1", s.Value)
+		}
+		for {
+			if err := stream.RecvMsg(nil); err == io.EOF {
+				break
+			}
+			if err := stream.SendMsg(nil); err != nil {
+				return err
+			}
+		}
+		return stream.SendMsg(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//the io.EOF that ends the stream isn't a message
+	if s, _ := series(r, "grpc_server_stream_msg_total", streamMethod, "received"); s.Value != 3 {
+		t.Errorf("received = %v, want 3", s.Value)
+	}
+	if s, _ := series(r, "grpc_server_stream_msg_total", streamMethod, "sent"); s.Value != 4 {
+		t.Errorf("sent = %v, want 4", s.Value)
+	}
+	//there's a gap between each message and the one before it in the same direction
+	if s, _ := series(r, "grpc_server_stream_msg_interval_seconds", streamMethod, "received"); s.Count != 2 {
+		t.Errorf("received intervals = %d, want 2", s.Count)
+	}
+	if s, _ := series(r, "grpc_server_stream_msg_interval_seconds", streamMethod, "sent"); s.Count != 3 {
+		t.Errorf("sent intervals = %d, want 3", s.Count)
+	}
+	if s, _ := series(r, "grpc_server_handled_total", "stream", streamMethod, "OK"); s.Value != 1 {
+		t.Errorf("handled = %v, want 1", s.Value)
+	}
+	if s, _ := series(r, "grpc_server_streams_open", streamMethod); s.Value != 0 {
+		t.Errorf("open streams = %v after the call, want 0", s.Value)
+	}
+
+	if got := sink.counts["grpc.server.stream_msg"]; got != 7 {
+		t.Errorf("sink got %d messages, want 7", got)
+	}
+	if got := sink.counts["grpc.server.handled"]; got != 1 {
+		t.Errorf("sink got %d calls, want 1", got)
+	}
+}
+
+func TestStreamMetricsIntervals(t *testing.T) {
+	r := metrics.NewRegistry()
+	interceptor := NewStreamMetrics(r)
+
+	info := &grpc.StreamServerInfo{FullMethod: streamMethod, IsServerStream: true}
+	err := interceptor(nil, &fakeServerStream{}, info, func(srv interface{}, stream grpc.ServerStream) error {
+		stream.SendMsg(nil)
+		time.Sleep(30 * time.Millisecond)
+		return stream.SendMsg(nil)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, ok := series(r, "grpc_server_stream_msg_interval_seconds", streamMethod, "sent")
+	if !ok || s.Count != 1 {
+		t.Fatalf("intervals = %+v, want one", s)
+	}
+	if s.Value < 0.03 {
+		t.Errorf("interval = %vs, want at least the 30ms between the messages", s.Value)
+	}
+	//DefBuckets start at 5ms, 10ms and 25ms, so the interval has to be in a later bucket
+	if s.BucketCounts[0]+s.BucketCounts[1]+s.BucketCounts[2] != 0 {
+		t.Errorf("bucket counts = %v, want nothing under 25ms", s.BucketCounts)
+	}
+}
+
+func TestStreamMetricsError(t *testing.T) {
+	r := metrics.NewRegistry()
+	interceptor := NewStreamMetrics(r)
+
+	//the client goes away after one message
+	ss := &endingStream{recv: 1, recvErr: grpc.Errorf(codes.Canceled, "context canceled")}
+	info := &grpc.StreamServerInfo{FullMethod: streamMethod, IsClientStream: true}
+	err := interceptor(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(nil); err != nil {
+				return err
+			}
+		}
+	})
+	if grpc.Code(err) != codes.Canceled {
+		t.Fatalf("err = %v, want Canceled", err)
+	}
+
+	if s, _ := series(r, "grpc_server_stream_msg_total", streamMethod, "received"); s.Value != 1 {
+		t.Errorf("received = %v, want 1", s.Value)
+	}
+	if s, _ := series(r, "grpc_server_handled_total", "stream", streamMethod, "Canceled"); s.Value != 1 {
+		t.Errorf("handled = %v, want 1 Canceled", s.Value)
+	}
+	//a stream that fails is closed too
+	if s, ok := series(r, "grpc_server_streams_open", streamMethod); !ok || s.Value != 0 {
+		t.Errorf("open streams = %+v after the call, want 0", s)
+	}
+}
+
+func TestStreamMetricsPanic(t *testing.T) {
+	r := metrics.NewRegistry()
+	interceptor := NewStreamMetrics(r)
+
+	func() {
+		defer func() { recover() }()
+		interceptor(nil, &fakeServerStream{}, &grpc.StreamServerInfo{FullMethod: streamMethod}, func(srv interface{}, stream grpc.ServerStream) error {
+			panic("handler bug")
+		})
+	}()
+
+	//the gauge is decremented on the way out even if a recovery interceptor further out turns the panic into an error
+	if s, ok := series(r, "grpc_server_streams_open", streamMethod); !ok || s.Value != 0 {
+		t.Errorf("open streams = %+v after a panic, want 0", s)
+	}
+}
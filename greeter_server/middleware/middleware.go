@@ -1,25 +1,14 @@
 package middleware
 
 import (
-	"errors"
-	"fmt"
 	"time"
 
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
 )
 
-//UnaryMetrics for handling metrics for unary gRPC endpoints
-func UnaryMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-	now := time.Now()
-
-	resp, err = handler(ctx, req)
-
-	fmt.Printf("Metrics: [%s] took [%s]\n", info.FullMethod, time.Since(now))
-	return resp, err
-}
-
 //UnaryUniversalDeadline for add a deadline to unary endpoints
 func UnaryUniversalDeadline(d time.Duration) grpc.UnaryServerInterceptor {
 
@@ -38,7 +27,7 @@ func UnaryUniversalDeadline(d time.Duration) grpc.UnaryServerInterceptor {
 		//Will return an error if the deadline passes before the handler is finished... should also figure out how to stop the handler from continuing if possible?
 		select {
 		case <-ctx.Done():
-			return nil, errors.New("Unable to complete request due to deadline")
+			return nil, grpc.Errorf(codes.DeadlineExceeded, "Unable to complete request due to deadline")
 		case <-done:
 			return resp, err
 		}
@@ -48,23 +37,11 @@ func UnaryUniversalDeadline(d time.Duration) grpc.UnaryServerInterceptor {
 //UnaryAuth for handling logging for unary gRPC endpoints. Gets credentials from ctx and adds user info to ctx or rejects call
 func UnaryAuth() grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
-		//Get auth data that's passed from client in context.
-		md, ok := metadata.FromContext(ctx)
-		if !ok {
-			fmt.Println("Could not get metadata", err)
+		ctx, err = authenticate(ctx)
+		if err != nil {
+			return nil, err
 		}
 
-		//Check it to make sure it's good
-		cred := md[authKey]
-		fmt.Println("cred", cred)
-		if cred == nil {
-			//Reject call if not
-			return nil, errors.New("Not authorized to make this call!")
-		}
-
-		//Add user data to ctx.
-		ctx = context.WithValue(ctx, "user", cred)
-
 		//Pass to next handler
 		return handler(ctx, req)
 	}
@@ -72,15 +49,45 @@ func UnaryAuth() grpc.UnaryServerInterceptor {
 
 const authKey = "1"
 
-//StreamAuth for handling auth on streaming endpoints
+type userKey struct{}
+
+//UserFromContext returns the credentials the auth middleware attached to ctx
+func UserFromContext(ctx context.Context) []string {
+	cred, _ := ctx.Value(userKey{}).([]string)
+	return cred
+}
+
+//StreamAuth for handling auth on streaming endpoints. Adds user info to the stream's ctx or rejects call
 func StreamAuth() grpc.StreamServerInterceptor {
 
 	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
-		cred := ss.Context().Value(authKey)
-		if cred == nil {
-			return errors.New("Not authorized to make this call!")
+		ctx, err := authenticate(ss.Context())
+		if err != nil {
+			return err
 		}
 
-		return handler(srv, ss)
+		return handler(srv, WithStreamContext(ss, ctx))
 	}
 }
+
+//authenticate gets credentials from ctx and adds user info to ctx
+func authenticate(ctx context.Context) (context.Context, error) {
+	//Get auth data that's passed from client in context.
+	md, ok := metadata.FromContext(ctx)
+
+	//Check it to make sure it's good
+	cred := md[authKey]
+	if cred == nil {
+		//Reject call if not. Only the reason is logged, never what the client sent.
+		reason := "no credentials"
+		if !ok {
+			reason = "no metadata"
+		}
+		DefaultLogger.Warn(ctx, "rejecting unauthenticated call", Field{"reason", reason})
+		return nil, grpc.Errorf(codes.Unauthenticated, "Not authorized to make this call!")
+	}
+
+	//Add user data to ctx, and let an access log running before auth know who the call is from
+	recordPrincipal(ctx, cred)
+	return context.WithValue(ctx, userKey{}, cred), nil
+}
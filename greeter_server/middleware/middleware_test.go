@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestAuthLogsReasonNotCredentials(t *testing.T) {
+	rec := NewRecorder()
+	old := DefaultLogger
+	DefaultLogger = rec
+	defer func() { DefaultLogger = old }()
+
+	tests := []struct {
+		name   string
+		ctx    context.Context
+		code   codes.Code
+		reason string
+	}{
+		{"accepted", metadata.NewContext(context.Background(), metadata.Pairs(authKey, "s3cret")), codes.OK, ""},
+		{"no credentials", metadata.NewContext(context.Background(), metadata.Pairs("authorization", "s3cret")), codes.Unauthenticated, "no credentials"},
+		{"no metadata", context.Background(), codes.Unauthenticated, "no metadata"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec.Reset()
+			if err := callUnary(test.ctx, UnaryAuth(), testMethod, nil, nil, nil); grpc.Code(err) != test.code {
+				t.Errorf("unary err = %v, want %s", err, test.code)
+			}
+			if err := callStream(test.ctx, StreamAuth(), testMethod, nil, nil); grpc.Code(err) != test.code {
+				t.Errorf("stream err = %v, want %s", err, test.code)
+			}
+
+			entries := rec.Entries()
+			for _, e := range entries {
+				if strings.Contains(fmt.Sprint(e.Msg, e.Fields), "s3cret") {
+					t.Errorf("credentials were logged: %+v", e)
+				}
+			}
+			if test.reason == "" {
+				if len(entries) != 0 {
+					t.Errorf("logged %+v for an accepted call", entries)
+				}
+				return
+			}
+			if len(entries) != 2 || entries[0].Level != LevelWarn || entries[0].Field("reason") != test.reason {
+				t.Errorf("logged %+v, want a warning with reason %q for each call", entries, test.reason)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+//Redacted replaces the value of redacted fields in logged payloads
+const Redacted = "[REDACTED]"
+
+type payloadOptions struct {
+	enabled bool
+	maxSize int
+	redact  [][]string
+}
+
+//LogPayloads logs requests and responses as JSON, including every message on streams.
+//Fields are given as dot separated JSON paths like "name" or "user.password" and have their values replaced by Redacted.
+//Payloads longer than maxSize bytes are truncated, 0 means no limit.
+func LogPayloads(maxSize int, redact ...string) LoggingOption {
+	return func(o *loggingOptions) {
+		o.payloads.enabled = true
+		o.payloads.maxSize = maxSize
+		for _, path := range redact {
+			o.payloads.redact = append(o.payloads.redact, strings.Split(path, "."))
+		}
+	}
+}
+
+//format returns m as redacted and truncated JSON
+func (p payloadOptions) format(m interface{}) string {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Sprintf("<could not marshal %T: %v>", m, err)
+	}
+
+	if len(p.redact) > 0 {
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return fmt.Sprintf("<could not redact %T: %v>", m, err)
+		}
+
+		for _, path := range p.redact {
+			redact(v, path)
+		}
+
+		if b, err = json.Marshal(v); err != nil {
+			return fmt.Sprintf("<could not marshal %T: %v>", m, err)
+		}
+	}
+
+	if p.maxSize > 0 && len(b) > p.maxSize {
+		//Cut at the start of a character so a multi-byte one isn't split
+		n := p.maxSize
+		for n > 0 && !utf8.RuneStart(b[n]) {
+			n--
+		}
+		return string(b[:n]) + "...(truncated)"
+	}
+	return string(b)
+}
+
+//redact masks the field at path in a value decoded from JSON. Lists are redacted element by element.
+func redact(v interface{}, path []string) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		field, ok := v[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			v[path[0]] = Redacted
+			return
+		}
+		redact(field, path[1:])
+	case []interface{}:
+		for _, e := range v {
+			redact(e, path)
+		}
+	}
+}
+
+//CheckRedactPaths returns an error for the first path that isn't a JSON field of any of the messages.
+//Paths into maps and interface{} fields can't be checked past that point and are accepted.
+func CheckRedactPaths(paths []string, messages ...interface{}) error {
+	for _, path := range paths {
+		found := false
+		for _, m := range messages {
+			if hasPath(reflect.TypeOf(m), strings.Split(path, ".")) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			names := make([]string, len(messages))
+			for i, m := range messages {
+				names[i] = fmt.Sprintf("%T", m)
+			}
+			return fmt.Errorf("%q isn't a field of %s", path, strings.Join(names, " or "))
+		}
+	}
+	return nil
+}
+
+//hasPath reports whether path leads to a field of t when t is marshaled to JSON. Lists are looked through like redact does.
+func hasPath(t reflect.Type, path []string) bool {
+	if t == nil {
+		return false
+	}
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+		t = t.Elem()
+	}
+	if len(path) == 0 {
+		return true
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return true
+	case reflect.Map:
+		return hasPath(t.Elem(), path[1:])
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if tag == "-" || f.PkgPath != "" && !f.Anonymous {
+				continue
+			}
+
+			//Untagged embedded structs have their fields marshaled inline
+			if f.Anonymous && tag == "" {
+				if hasPath(f.Type, path) {
+					return true
+				}
+				continue
+			}
+
+			name := f.Name
+			if n := strings.Split(tag, ",")[0]; n != "" {
+				name = n
+			}
+			if name == path[0] && hasPath(f.Type, path[1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
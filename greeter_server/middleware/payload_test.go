@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"testing"
+	"unicode/utf8"
+
+	"golang.org/x/net/context"
+)
+
+func TestLoggingPayloads(t *testing.T) {
+	req := &loginRequest{
+		Name:     "bob",
+		Password: "hunter2",
+		User:     &account{Name: "bob", Password: "hunter2"},
+		Users:    []account{{"amy", "pw1"}, {"cal", "pw2"}},
+	}
+
+	tests := []struct {
+		name    string
+		maxSize int
+		redact  []string
+		want    string
+	}{
+		{
+			name: "everything",
+			want: `{"name":"bob","password":"hunter2","user":{"name":"bob","password":"hunter2"},"users":[{"name":"amy","password":"pw1"},{"name":"cal","password":"pw2"}]}`,
+		},
+		{
+			name:   "top level",
+			redact: []string{"password"},
+			want:   `{"name":"bob","password":"[REDACTED]","user":{"name":"bob","password":"hunter2"},"users":[{"name":"amy","password":"pw1"},{"name":"cal","password":"pw2"}]}`,
+		},
+		{
+			name:   "nested and lists",
+			redact: []string{"user.password", "users.password"},
+			want:   `{"name":"bob","password":"hunter2","user":{"name":"bob","password":"[REDACTED]"},"users":[{"name":"amy","password":"[REDACTED]"},{"name":"cal","password":"[REDACTED]"}]}`,
+		},
+		{
+			name:   "whole object",
+			redact: []string{"user"},
+			want:   `{"name":"bob","password":"hunter2","user":"[REDACTED]","users":[{"name":"amy","password":"pw1"},{"name":"cal","password":"pw2"}]}`,
+		},
+		{
+			name:    "truncated",
+			maxSize: 20,
+			redact:  []string{"password"},
+			want:    `{"name":"bob","passw...(truncated)`,
+		},
+		{
+			name:    "fits",
+			maxSize: 1000,
+			redact:  []string{"password", "user", "users"},
+			want:    `{"name":"bob","password":"[REDACTED]","user":"[REDACTED]","users":"[REDACTED]"}`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := NewRecorder()
+			opt := LogPayloads(test.maxSize, test.redact...)
+			callUnary(context.Background(), NewUnaryLogging(rec, opt), testMethod, req, req, nil)
+
+			entries := rec.Entries()
+			if len(entries) != 2 {
+				t.Fatalf("got %d lines, want 2", len(entries))
+			}
+			if got := entries[0].Field("request"); got != test.want {
+				t.Errorf("request =\n%s\nwant\n%s", got, test.want)
+			}
+			if got := entries[1].Field("response"); got != test.want {
+				t.Errorf("response =\n%s\nwant\n%s", got, test.want)
+			}
+
+			rec.Reset()
+			callStream(context.Background(), NewStreamLogging(rec, opt), testMethod, req, nil)
+
+			var sent string
+			for _, e := range rec.Entries() {
+				if e.Msg == "stream message sent" {
+					sent = e.Field("payload")
+				}
+			}
+			if sent != test.want {
+				t.Errorf("stream payload =\n%s\nwant\n%s", sent, test.want)
+			}
+		})
+	}
+}
+
+func TestPayloadTruncationKeepsRunes(t *testing.T) {
+	//"é" and "世" are 2 and 3 bytes, the JSON starts with {"name":" which is 9
+	m := map[string]string{"name": "é世界"}
+
+	tests := []struct {
+		maxSize int
+		want    string
+	}{
+		{9, `{"name":"...(truncated)`},
+		{10, `{"name":"...(truncated)`},
+		{11, `{"name":"é...(truncated)`},
+		{12, `{"name":"é...(truncated)`},
+		{13, `{"name":"é...(truncated)`},
+		{14, `{"name":"é世...(truncated)`},
+		{100, `{"name":"é世界"}`},
+	}
+
+	for _, test := range tests {
+		got := payloadOptions{enabled: true, maxSize: test.maxSize}.format(m)
+		if got != test.want {
+			t.Errorf("maxSize %d: got %s, want %s", test.maxSize, got, test.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("maxSize %d: %q isn't valid UTF-8", test.maxSize, got)
+		}
+	}
+}
@@ -0,0 +1,440 @@
+package middleware
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+)
+
+//Params are the parameters a middleware is given in the config file
+type Params map[string]string
+
+//Duration parses the param as a time.Duration, e.g. "1s"
+func (p Params) Duration(key string) (time.Duration, error) {
+	v, ok := p[key]
+	if !ok {
+		return 0, fmt.Errorf("missing param %q", key)
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0, fmt.Errorf("param %q: %v", key, err)
+	}
+	return d, nil
+}
+
+//Int parses the param as an int, or returns def if it isn't set
+func (p Params) Int(key string, def int) (int, error) {
+	v, ok := p[key]
+	if !ok {
+		return def, nil
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("param %q: %v", key, err)
+	}
+	return i, nil
+}
+
+//List splits a comma separated param, trimming spaces and dropping empty entries, or returns nil if it isn't set
+func (p Params) List(key string) []string {
+	var list []string
+	for _, v := range strings.Split(p[key], ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+//UnaryConstructor builds a unary interceptor from its params
+type UnaryConstructor func(p Params) (grpc.UnaryServerInterceptor, error)
+
+//StreamConstructor builds a stream interceptor from its params
+type StreamConstructor func(p Params) (grpc.StreamServerInterceptor, error)
+
+//registration is a constructor and the params it reads
+type registration struct {
+	constructor interface{}
+	params      []string
+}
+
+var (
+	registryMu     sync.RWMutex
+	unaryRegistry  = map[string]registration{}
+	streamRegistry = map[string]registration{}
+)
+
+//RegisterUnary makes a unary interceptor available to config files under name.
+//params lists the params it reads; a config entry that sets any other param is rejected.
+func RegisterUnary(name string, c UnaryConstructor, params ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	unaryRegistry[name] = registration{constructor: c, params: params}
+}
+
+//RegisterStream makes a stream interceptor available to config files under name.
+//params lists the params it reads; a config entry that sets any other param is rejected.
+func RegisterStream(name string, c StreamConstructor, params ...string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	streamRegistry[name] = registration{constructor: c, params: params}
+}
+
+//RegisterMiddleware makes a Middleware available to config files under name, for both unary and streaming endpoints
+func RegisterMiddleware(name string, c func(p Params) (Middleware, error), params ...string) {
+	RegisterUnary(name, func(p Params) (grpc.UnaryServerInterceptor, error) {
+		mw, err := c(p)
+		if err != nil {
+			return nil, err
+		}
+		return mw.Unary(), nil
+	}, params...)
+	RegisterStream(name, func(p Params) (grpc.StreamServerInterceptor, error) {
+		mw, err := c(p)
+		if err != nil {
+			return nil, err
+		}
+		return mw.Stream(), nil
+	}, params...)
+}
+
+//NewUnary builds the unary interceptor registered under name
+func NewUnary(name string, p Params) (grpc.UnaryServerInterceptor, error) {
+	registryMu.RLock()
+	r, ok := unaryRegistry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown unary middleware %q, have %v", name, registered(unaryRegistry))
+	}
+	if err := r.checkParams(p); err != nil {
+		return nil, err
+	}
+	return r.constructor.(UnaryConstructor)(p)
+}
+
+//NewStream builds the stream interceptor registered under name
+func NewStream(name string, p Params) (grpc.StreamServerInterceptor, error) {
+	registryMu.RLock()
+	r, ok := streamRegistry[name]
+	registryMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown stream middleware %q, have %v", name, registered(streamRegistry))
+	}
+	if err := r.checkParams(p); err != nil {
+		return nil, err
+	}
+	return r.constructor.(StreamConstructor)(p)
+}
+
+//checkParams rejects params the constructor doesn't read, which are most likely typos that would otherwise be ignored
+func (r registration) checkParams(p Params) error {
+	var unknown []string
+	for key := range p {
+		if !contains(r.params, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	if len(r.params) == 0 {
+		return fmt.Errorf("unknown params %q, it doesn't take any", unknown)
+	}
+	return fmt.Errorf("unknown params %q, have %q", unknown, r.params)
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func registered(registry map[string]registration) []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+
+	var names []string
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	RegisterUnary("logging", func(p Params) (grpc.UnaryServerInterceptor, error) {
+		opts, err := loggingParams(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(opts) == 0 {
+			return UnaryLogging, nil
+		}
+		return NewUnaryLogging(DefaultLogger, opts...), nil
+	}, loggingParamNames...)
+	RegisterStream("logging", func(p Params) (grpc.StreamServerInterceptor, error) {
+		opts, err := loggingParams(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(opts) == 0 {
+			return StreamLogging, nil
+		}
+		return NewStreamLogging(DefaultLogger, opts...), nil
+	}, loggingParamNames...)
+
+	RegisterMiddleware("requestid", func(Params) (Middleware, error) {
+		return RequestID, nil
+	})
+
+	RegisterMiddleware("inflight", func(Params) (Middleware, error) {
+		return DefaultInFlight.Middleware(), nil
+	})
+
+	RegisterMiddleware("requests", func(Params) (Middleware, error) {
+		return DefaultRequestLog.Middleware(), nil
+	})
+
+	RegisterMiddleware("accesslog", func(p Params) (Middleware, error) {
+		t, err := accessLogParams(p)
+		if err != nil {
+			return Middleware{}, err
+		}
+		return NewAccessLog(accessLogWriter{path: t.path}), nil
+	}, "path", "maxSize", "maxAge", "maxBackups")
+
+	RegisterUnary("tracing", func(Params) (grpc.UnaryServerInterceptor, error) {
+		return UnaryTracing, nil
+	})
+	RegisterStream("tracing", func(Params) (grpc.StreamServerInterceptor, error) {
+		return StreamTracing, nil
+	})
+
+	RegisterUnary("metrics", func(Params) (grpc.UnaryServerInterceptor, error) {
+		return UnaryMetrics, nil
+	})
+	RegisterStream("metrics", func(Params) (grpc.StreamServerInterceptor, error) {
+		return StreamMetrics, nil
+	})
+
+	RegisterUnary("deadline", func(p Params) (grpc.UnaryServerInterceptor, error) {
+		d, err := p.Duration("duration")
+		if err != nil {
+			return nil, err
+		}
+		return UnaryUniversalDeadline(d), nil
+	}, "duration")
+
+	RegisterUnary("auth", func(Params) (grpc.UnaryServerInterceptor, error) {
+		return UnaryAuth(), nil
+	})
+	RegisterStream("auth", func(Params) (grpc.StreamServerInterceptor, error) {
+		return StreamAuth(), nil
+	})
+}
+
+var loggingParamNames = []string{"messages", "payloads", "maxPayload", "redact", "redactTypes", "sampleEvery", "maxPerSecond", "reportEvery"}
+
+//loggingParams turns the logging params into options:
+//"messages": "true" logs every stream message, "payloads": "true" logs payloads with
+//"maxPayload" limiting their size and "redact" listing comma separated field paths to mask, which are checked against
+//the proto messages listed in "redactTypes", "sampleEvery", "maxPerSecond" and "reportEvery" set up sampling
+func loggingParams(p Params) ([]LoggingOption, error) {
+	var opts []LoggingOption
+	if p["messages"] == "true" {
+		opts = append(opts, LogMessages())
+	}
+
+	if p["payloads"] == "true" {
+		maxSize, err := p.Int("maxPayload", 0)
+		if err != nil {
+			return nil, err
+		}
+		redact := p.List("redact")
+		if err := checkRedactParams(redact, p.List("redactTypes")); err != nil {
+			return nil, err
+		}
+		opts = append(opts, LogPayloads(maxSize, redact...))
+	}
+
+	everyN, err := p.Int("sampleEvery", 1)
+	if err != nil {
+		return nil, err
+	}
+	maxPerSecond, err := p.Int("maxPerSecond", 0)
+	if err != nil {
+		return nil, err
+	}
+	if everyN > 1 || maxPerSecond > 0 {
+		reportEvery := 10 * time.Second
+		if _, ok := p["reportEvery"]; ok {
+			if reportEvery, err = p.Duration("reportEvery"); err != nil {
+				return nil, err
+			}
+		}
+		opts = append(opts, sharedSampling(everyN, maxPerSecond, reportEvery))
+	}
+
+	return opts, nil
+}
+
+var (
+	samplersMu sync.Mutex
+	samplers   = map[string]*sampler{}
+)
+
+//sharedSampling is WithSampling with one sampler for every logging entry that has the same sampling params, so a unary and
+//a stream entry configured alike share the per second cap and the report, and the counts carry over config reloads
+func sharedSampling(everyN, maxPerSecond int, reportEvery time.Duration) LoggingOption {
+	key := fmt.Sprintf("%d/%d/%s", everyN, maxPerSecond, reportEvery)
+
+	samplersMu.Lock()
+	s, ok := samplers[key]
+	if !ok {
+		s = newSampler(everyN, maxPerSecond, reportEvery)
+		samplers[key] = s
+	}
+	samplersMu.Unlock()
+
+	return func(o *loggingOptions) {
+		o.sampler = s
+	}
+}
+
+//checkRedactParams makes sure every redact path is a field of one of the named proto messages,
+//so a typo in the config fails the reload instead of logging what it was meant to hide
+func checkRedactParams(redact, typeNames []string) error {
+	if len(redact) == 0 {
+		return nil
+	}
+	if len(typeNames) == 0 {
+		return fmt.Errorf("missing param %q, the messages to check %q against", "redactTypes", "redact")
+	}
+
+	var messages []interface{}
+	for _, name := range typeNames {
+		t := proto.MessageType(name)
+		if t == nil {
+			return fmt.Errorf("param %q: unknown message type %q", "redactTypes", name)
+		}
+		messages = append(messages, reflect.Zero(t).Interface())
+	}
+
+	if err := CheckRedactPaths(redact, messages...); err != nil {
+		return fmt.Errorf("param %q: %v", "redact", err)
+	}
+	return nil
+}
+
+//accessLogTarget is the file an accesslog entry writes to and its rotation limits
+type accessLogTarget struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+}
+
+//accessLogParams checks the params of an accesslog entry: the file given by "path", rotated by "maxSize" bytes
+//(default 100MB) and "maxAge" (default 24h), keeping "maxBackups" old files (default 10)
+func accessLogParams(p Params) (accessLogTarget, error) {
+	path := p["path"]
+	if path == "" {
+		return accessLogTarget{}, fmt.Errorf("missing param %q", "path")
+	}
+
+	maxSize, err := p.Int("maxSize", 100<<20)
+	if err != nil {
+		return accessLogTarget{}, err
+	}
+	maxAge := 24 * time.Hour
+	if _, ok := p["maxAge"]; ok {
+		if maxAge, err = p.Duration("maxAge"); err != nil {
+			return accessLogTarget{}, err
+		}
+	}
+	maxBackups, err := p.Int("maxBackups", 10)
+	if err != nil {
+		return accessLogTarget{}, err
+	}
+
+	return accessLogTarget{path: path, maxSize: int64(maxSize), maxAge: maxAge, maxBackups: maxBackups}, nil
+}
+
+var (
+	accessLogFilesMu sync.RWMutex
+	//accessLogFiles are the files of the applied config, by path. They stay open across reloads that keep using them.
+	accessLogFiles = map[string]*RotatingFile{}
+)
+
+//accessLogWriter writes to the open file for path. It doesn't open anything itself, so building a config has no side effects;
+//Config.Apply opens the file. Once a reload drops the path, calls still finishing on the old chain can't write any more.
+type accessLogWriter struct {
+	path string
+}
+
+func (w accessLogWriter) Write(p []byte) (int, error) {
+	accessLogFilesMu.RLock()
+	f := accessLogFiles[w.path]
+	accessLogFilesMu.RUnlock()
+
+	if f == nil {
+		return 0, fmt.Errorf("access log %s isn't open, the config using it hasn't been applied or was replaced", w.path)
+	}
+	return f.Write(p)
+}
+
+//applyAccessLogs makes targets the open access log files. Files that are already open are kept and get the new limits, and
+//files no longer used are closed. If a file can't be opened nothing changes.
+func applyAccessLogs(targets []accessLogTarget) error {
+	accessLogFilesMu.Lock()
+	defer accessLogFilesMu.Unlock()
+
+	files := map[string]*RotatingFile{}
+	var opened []*RotatingFile
+	for _, t := range targets {
+		if files[t.path] != nil {
+			continue
+		}
+		if f, ok := accessLogFiles[t.path]; ok {
+			files[t.path] = f
+			continue
+		}
+
+		f, err := NewRotatingFile(t.path, t.maxSize, t.maxAge, t.maxBackups)
+		if err != nil {
+			for _, f := range opened {
+				f.Close()
+			}
+			return err
+		}
+		opened = append(opened, f)
+		files[t.path] = f
+	}
+
+	//Entries that share a path should give it the same limits, otherwise the last one wins
+	for _, t := range targets {
+		files[t.path].SetLimits(t.maxSize, t.maxAge, t.maxBackups)
+	}
+
+	for path, f := range accessLogFiles {
+		if files[path] == nil {
+			f.Close()
+		}
+	}
+	accessLogFiles = files
+	return nil
+}
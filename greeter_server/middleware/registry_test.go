@@ -0,0 +1,241 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	//registers the helloworld messages for redactTypes
+	_ "github.com/troylelandshields/helloworld_grpctooling_poc/helloworld"
+)
+
+func TestParamsList(t *testing.T) {
+	tests := []struct {
+		value string
+		set   bool
+		want  []string
+	}{
+		{"", false, nil},
+		{"", true, nil},
+		{"a", true, []string{"a"}},
+		{"a,b", true, []string{"a", "b"}},
+		{" a , b ", true, []string{"a", "b"}},
+		{"a,,b,", true, []string{"a", "b"}},
+		{" , ", true, nil},
+	}
+
+	for _, test := range tests {
+		p := Params{}
+		if test.set {
+			p["list"] = test.value
+		}
+		if got := p.List("list"); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("List(%q) = %q, want %q", test.value, got, test.want)
+		}
+	}
+}
+
+type embeddedFields struct {
+	Tracking string `json:"tracking"`
+}
+
+type checkedRequest struct {
+	embeddedFields
+	Name     string                 `json:"name"`
+	Renamed  string                 `json:"other,omitempty"`
+	Untagged string                 //marshaled as "Untagged"
+	Skipped  string                 `json:"-"`
+	User     *account               `json:"user"`
+	Users    []account              `json:"users"`
+	Labels   map[string]account     `json:"labels"`
+	Extra    map[string]interface{} `json:"extra"`
+	private  string
+}
+
+func TestCheckRedactPaths(t *testing.T) {
+	tests := []struct {
+		path string
+		ok   bool
+	}{
+		{"name", true},
+		{"other", true},
+		{"Renamed", false},
+		{"Untagged", true},
+		{"Skipped", false},
+		{"private", false},
+		{"tracking", true},
+		{"user", true},
+		{"user.password", true},
+		{"user.pasword", false},
+		{"users.password", true},
+		{"labels.anything.name", true},
+		{"labels.anything.nope", false},
+		{"extra.anything.at.all", true},
+		{"name.deeper", false},
+		{"missing", false},
+	}
+
+	for _, test := range tests {
+		err := CheckRedactPaths([]string{test.path}, &checkedRequest{})
+		if (err == nil) != test.ok {
+			t.Errorf("CheckRedactPaths(%q) = %v, want ok %v", test.path, err, test.ok)
+		}
+	}
+
+	//a path only has to be in one of the messages
+	if err := CheckRedactPaths([]string{"name", "password"}, &checkedRequest{}, &account{}); err != nil {
+		t.Errorf("CheckRedactPaths with two messages: %v", err)
+	}
+}
+
+func TestLoggingParamsRedact(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		wantErr string
+	}{
+		{"no redact", Params{"payloads": "true"}, ""},
+		{"request field", Params{"payloads": "true", "redact": "name", "redactTypes": "helloworld.HelloRequest"}, ""},
+		{
+			name:   "field of either message",
+			params: Params{"payloads": "true", "redact": " name , message ", "redactTypes": "helloworld.HelloRequest, helloworld.HelloReply"},
+		},
+		{
+			name:    "typo",
+			params:  Params{"payloads": "true", "redact": "nmae", "redactTypes": "helloworld.HelloRequest"},
+			wantErr: `"nmae" isn't a field of *helloworld.HelloRequest`,
+		},
+		{
+			name:    "no types",
+			params:  Params{"payloads": "true", "redact": "name"},
+			wantErr: `missing param "redactTypes"`,
+		},
+		{
+			name:    "unknown type",
+			params:  Params{"payloads": "true", "redact": "name", "redactTypes": "helloworld.Nope"},
+			wantErr: `unknown message type "helloworld.Nope"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			unary, err := NewUnary("logging", test.params)
+			stream, streamErr := NewStream("logging", test.params)
+			if test.wantErr == "" {
+				if err != nil || streamErr != nil {
+					t.Fatal(err, streamErr)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("unary err = %v, want it to contain %s", err, test.wantErr)
+			}
+			if streamErr == nil || !strings.Contains(streamErr.Error(), test.wantErr) {
+				t.Errorf("stream err = %v, want it to contain %s", streamErr, test.wantErr)
+			}
+			//a chain must never be built from an interceptor that failed to configure
+			if unary != nil || stream != nil {
+				t.Error("got an interceptor along with the error")
+			}
+		})
+	}
+}
+
+func TestAccessLogConfigApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "accesslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	first := filepath.Join(dir, "first.log")
+	second := filepath.Join(dir, "second.log")
+
+	parse := func(config string) (*Config, error) {
+		config = strings.NewReplacer("FIRST", first, "SECOND", second).Replace(config)
+		return ParseConfig(strings.NewReader(config))
+	}
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	defer applyAccessLogs(nil)
+
+	cfg, err := parse(`{"unary": [{"name": "accesslog", "params": {"path": "FIRST", "maxSize": "100", "maxAge": "1h", "maxBackups": "3"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//building the config doesn't open anything, so it can't be used before it's applied
+	if exists(first) {
+		t.Fatal("parsing opened the file")
+	}
+	if _, err := (accessLogWriter{first}).Write([]byte("x\n")); err == nil {
+		t.Error("wrote to an access log that wasn't applied")
+	}
+	if err := cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	f := accessLogFiles[first]
+	if f == nil || !exists(first) {
+		t.Fatal("applying didn't open the file")
+	}
+
+	//a reload that fails on a later entry changes nothing
+	if _, err := parse(`{"unary": [{"name": "accesslog", "params": {"path": "FIRST", "maxSize": "200"}}, {"name": "nope"}]}`); err == nil {
+		t.Fatal("no error for an unknown middleware")
+	}
+	if _, err := parse(`{"unary": [{"name": "accesslog", "params": {"path": "SECOND"}}, {"name": "deadline"}]}`); err == nil {
+		t.Fatal("no error for a deadline without a duration")
+	}
+	if f.maxSize != 100 || exists(second) {
+		t.Errorf("a failed reload was applied: maxSize %d, second file opened %v", f.maxSize, exists(second))
+	}
+
+	//a reload that keeps the path keeps the open file and updates its limits
+	cfg, err = parse(`{"unary": [{"name": "accesslog", "params": {"path": "FIRST", "maxSize": "200", "maxAge": "2h", "maxBackups": "5"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if accessLogFiles[first] != f {
+		t.Fatal("the same path was opened twice")
+	}
+	if f.maxSize != 200 || f.maxAge != 2*time.Hour || f.maxBackups != 5 {
+		t.Errorf("limits = %d, %s, %d, want 200, 2h, 5", f.maxSize, f.maxAge, f.maxBackups)
+	}
+
+	//a file that can't be opened leaves the applied config alone
+	cfg, err = parse(`{"unary": [{"name": "accesslog", "params": {"path": "SECOND"}}, {"name": "accesslog", "params": {"path": "` + filepath.Join(dir, "missing", "x.log") + `"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(); err == nil {
+		t.Fatal("no error for a file in a missing directory")
+	}
+	if accessLogFiles[first] != f || accessLogFiles[second] != nil {
+		t.Error("a config that failed to apply changed the open files")
+	}
+
+	//a reload that drops the path closes the file
+	cfg, err = parse(`{"unary": [{"name": "accesslog", "params": {"path": "SECOND"}}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.f.Write([]byte("x\n")); err == nil {
+		t.Error("the dropped file is still open")
+	}
+	if _, err := (accessLogWriter{first}).Write([]byte("x\n")); err == nil {
+		t.Error("wrote to a dropped access log")
+	}
+	if _, err := (accessLogWriter{second}).Write([]byte("x\n")); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/metadata"
+)
+
+//RequestIDKey is the metadata key the request ID is read from and sent back in
+const RequestIDKey = "x-request-id"
+
+type requestIDKey struct{}
+
+//maxRequestIDLength is the longest request ID accepted from a client. IDs end up in every log line and response, so
+//longer ones, or ones with characters outside validRequestIDChar, are replaced with a generated ID.
+const maxRequestIDLength = 128
+
+//RequestID reads the request ID the client sent, or generates one, and adds it to the context and the response headers.
+//It should run before the logging middleware so the ID shows up in the logs. Problems are logged to DefaultLogger.
+var RequestID = NewRequestID(nil)
+
+//NewRequestID creates the RequestID middleware logging to logger, or to DefaultLogger if logger is nil
+func NewRequestID(logger Logger) Middleware {
+	return Middleware{
+		Name: "requestid",
+		Before: func(ctx context.Context, info *CallInfo) (context.Context, error) {
+			l := logger
+			if l == nil {
+				l = DefaultLogger
+			}
+
+			var id string
+			if md, ok := metadata.FromContext(ctx); ok && len(md[RequestIDKey]) > 0 {
+				id = md[RequestIDKey][0]
+			}
+			if id != "" && !validRequestID(id) {
+				//The ID itself isn't logged, it's exactly what shouldn't end up in the logs
+				l.Warn(ctx, "replacing invalid request id", Field{"method", info.FullMethod}, Field{"length", strconv.Itoa(len(id))})
+				id = ""
+			}
+			if id == "" {
+				id = newRequestID()
+			}
+
+			if err := SetHeader(ctx, metadata.Pairs(RequestIDKey, id)); err != nil {
+				l.Warn(ctx, "could not send request id", Field{"method", info.FullMethod}, Field{"error", err.Error()})
+			}
+
+			return context.WithValue(ctx, requestIDKey{}, id), nil
+		},
+	}
+}
+
+func validRequestID(id string) bool {
+	if len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if !validRequestIDChar(id[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+//validRequestIDChar allows letters, digits and the separators UUIDs and common tracing IDs use
+func validRequestIDChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	case c == '-', c == '_', c == '.', c == ':':
+		return true
+	}
+	return false
+}
+
+//RequestIDFromContext returns the request ID added by RequestID, or "" if there isn't one
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
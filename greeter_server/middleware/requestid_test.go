@@ -0,0 +1,152 @@
+package middleware
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestLoggingRequestID(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		name     string
+		incoming metadata.MD
+		want     *regexp.Regexp
+	}{
+		{"from client", metadata.Pairs(RequestIDKey, "abc-123"), regexp.MustCompile(`^abc-123$`)},
+		{"generated", nil, generated},
+		{"empty is replaced", metadata.Pairs(RequestIDKey, ""), generated},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			if test.incoming != nil {
+				ctx = metadata.NewContext(ctx, test.incoming)
+			}
+
+			rec := NewRecorder()
+			unary := chainUnary(RequestID.Unary(), NewUnaryLogging(rec))
+			callUnary(ctx, unary, testMethod, nil, nil, nil)
+
+			stream := chainStream(RequestID.Stream(), NewStreamLogging(rec))
+			callStream(ctx, stream, testMethod, &loginRequest{}, nil)
+
+			entries := rec.Entries()
+			if len(entries) != 4 {
+				t.Fatalf("got %d lines, want 4: %v", len(entries), entries)
+			}
+			first := entries[0].Field("requestID")
+			for i, e := range entries {
+				id := e.Field("requestID")
+				if !test.want.MatchString(id) {
+					t.Errorf("line %d requestID = %q, want %s", i, id, test.want)
+				}
+				//Each call gets its own generated ID but both lines of a call share it
+				if i%2 == 1 && id != entries[i-1].Field("requestID") {
+					t.Errorf("line %d requestID = %q, want %q like the line before", i, id, entries[i-1].Field("requestID"))
+				}
+			}
+			if test.incoming == nil && first == entries[2].Field("requestID") {
+				t.Errorf("both calls got request ID %q", first)
+			}
+		})
+	}
+}
+
+func TestRequestIDValidation(t *testing.T) {
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+	tests := []struct {
+		name     string
+		id       string
+		replaced bool
+	}{
+		{"uuid", "0f8fad5b-d9cb-469f-a165-70867728950e", false},
+		{"separators", "svc.host:1234_a", false},
+		{"longest", strings.Repeat("a", maxRequestIDLength), false},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1), true},
+		{"newline", "abc\nINFO forged line", true},
+		{"spaces", "abc def", true},
+		{"quotes", `abc"def`, true},
+		{"unicode", "abcé", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := NewRecorder()
+			ctx := metadata.NewContext(context.Background(), metadata.Pairs(RequestIDKey, test.id))
+
+			var id string
+			callUnary(ctx, chainUnary(NewRequestID(rec).Unary(), func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+				id = RequestIDFromContext(ctx)
+				return handler(ctx, req)
+			}), testMethod, nil, nil, nil)
+
+			var warned bool
+			for _, e := range rec.Entries() {
+				if e.Msg == "replacing invalid request id" {
+					warned = true
+					if e.Level != LevelWarn {
+						t.Errorf("level = %s, want WARN", e.Level)
+					}
+					if strings.Contains(fmt.Sprint(e.Fields), test.id) {
+						t.Errorf("the invalid id was logged: %v", e.Fields)
+					}
+				}
+			}
+
+			if !test.replaced {
+				if id != test.id || warned {
+					t.Errorf("id = %q, warned %v, want %q kept", id, warned, test.id)
+				}
+				return
+			}
+			if !generated.MatchString(id) || !warned {
+				t.Errorf("id = %q, warned %v, want it replaced with a generated id", id, warned)
+			}
+		})
+	}
+}
+
+func TestRequestIDStreamHeader(t *testing.T) {
+	rec := NewRecorder()
+
+	//an interceptor further out that watches the headers the stream sets
+	var hooked metadata.MD
+	watch := func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		w := wrapServerStream(ss)
+		w.RegisterSetHeaderMiddleware(func(next MetadataHandler) MetadataHandler {
+			return MetadataFunc(func(md metadata.MD) error {
+				hooked = metadata.Join(hooked, md)
+				return next.Metadata(md)
+			})
+		})
+		return handler(srv, w)
+	}
+
+	ss := &fakeServerStream{ctx: metadata.NewContext(context.Background(), metadata.Pairs(RequestIDKey, "abc-123"))}
+	info := &grpc.StreamServerInfo{FullMethod: testMethod, IsServerStream: true}
+	err := chainStream(watch, NewRequestID(rec).Stream())(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := hooked[RequestIDKey]; len(got) != 1 || got[0] != "abc-123" {
+		t.Errorf("SetHeader middleware saw %v, want the request id", hooked)
+	}
+	if got := ss.header[RequestIDKey]; len(got) != 1 || got[0] != "abc-123" {
+		t.Errorf("stream header = %v, want the request id", ss.header)
+	}
+	if entries := rec.Entries(); len(entries) != 0 {
+		t.Errorf("logged %+v, want nothing", entries)
+	}
+}
@@ -0,0 +1,346 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//maxRequestEvents limits the message events kept for one call, so a long stream can't take over the log
+const maxRequestEvents = 100
+
+//RequestLog keeps the most recent finished calls in a ring buffer so they can be looked at on the admin server,
+//in the spirit of golang.org/x/net/trace
+type RequestLog struct {
+	mu      sync.Mutex
+	entries []RequestTrace
+	next    int
+	full    bool
+	//showMetadata are the metadata keys whose values are recorded, every other key is recorded as Redacted
+	showMetadata map[string]bool
+}
+
+//RequestTrace is one finished call
+type RequestTrace struct {
+	FullMethod string        `json:"fullMethod"`
+	Stream     bool          `json:"stream"`
+	RequestID  string        `json:"requestID,omitempty"`
+	TraceID    string        `json:"traceID,omitempty"`
+	Peer       string        `json:"peer,omitempty"`
+	Start      time.Time     `json:"start"`
+	Duration   time.Duration `json:"duration"`
+	Code       string        `json:"code"`
+	Error      string        `json:"error,omitempty"`
+
+	Metadata    map[string][]string `json:"metadata,omitempty"`
+	Annotations []RequestAnnotation `json:"annotations,omitempty"`
+	Events      []MessageEvent      `json:"events,omitempty"`
+	//DroppedEvents counts the message events past maxRequestEvents
+	DroppedEvents int `json:"droppedEvents,omitempty"`
+}
+
+//RequestAnnotation is a note added to a call with Annotate
+type RequestAnnotation struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+//MessageEvent is a message received or sent during a call
+type MessageEvent struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Size      int64     `json:"size"`
+}
+
+//requestRecord is a call that's still running. Messages can be sent and received at the same time, so it has its own lock.
+type requestRecord struct {
+	mu    sync.Mutex
+	trace RequestTrace
+}
+
+type requestRecordKey struct{}
+
+//DefaultRequestLog is the log used by the default middleware and the "requests" registry entry
+var DefaultRequestLog = NewRequestLog(200)
+
+//DefaultShownMetadata are the metadata keys a RequestLog records the values of. Anything else the client sends, like
+//authorization, cookies or API keys, only shows up with its value Redacted.
+var DefaultShownMetadata = []string{":authority", "content-type", "user-agent", "grpc-timeout", "grpc-encoding",
+	"grpc-accept-encoding", RequestIDKey, "traceparent", "tracestate"}
+
+//NewRequestLog creates a RequestLog that keeps the last size calls. It panics if size isn't positive.
+//The values of DefaultShownMetadata and the showMetadata keys are recorded; other metadata is Redacted.
+func NewRequestLog(size int, showMetadata ...string) *RequestLog {
+	if size <= 0 {
+		panic(fmt.Sprintf("middleware: request log size must be positive, got %d", size))
+	}
+
+	l := &RequestLog{entries: make([]RequestTrace, size), showMetadata: map[string]bool{}}
+	for _, keys := range [][]string{DefaultShownMetadata, showMetadata} {
+		for _, k := range keys {
+			//metadata keys are always lower case
+			l.showMetadata[strings.ToLower(k)] = true
+		}
+	}
+	return l
+}
+
+//Annotate adds a note to the call in ctx, which shows up on /debug/requests. It does nothing if the call isn't being recorded.
+func Annotate(ctx context.Context, format string, args ...interface{}) {
+	r, ok := ctx.Value(requestRecordKey{}).(*requestRecord)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	r.trace.Annotations = append(r.trace.Annotations, RequestAnnotation{Time: time.Now(), Message: fmt.Sprintf(format, args...)})
+	r.mu.Unlock()
+}
+
+//Middleware returns middleware that records calls to the log. It should run after the request ID and tracing middleware so
+//their IDs are recorded too.
+func (l *RequestLog) Middleware() Middleware {
+	return Middleware{
+		Name: "requests",
+		Before: func(ctx context.Context, info *CallInfo) (context.Context, error) {
+			r := &requestRecord{trace: RequestTrace{
+				FullMethod: info.FullMethod,
+				Stream:     info.IsClientStream || info.IsServerStream,
+				RequestID:  RequestIDFromContext(ctx),
+				Peer:       peerAddress(ctx),
+				Start:      time.Now(),
+				Metadata:   l.metadata(ctx),
+			}}
+			if sc, ok := tracing.SpanContextFromContext(ctx); ok {
+				r.trace.TraceID = sc.TraceID.String()
+			}
+
+			return context.WithValue(ctx, requestRecordKey{}, r), nil
+		},
+		OnRecv: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			recordMessage(ctx, "received", m)
+			return nil
+		},
+		OnSend: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			recordMessage(ctx, "sent", m)
+			return nil
+		},
+		After: func(ctx context.Context, info *CallInfo, err error) error {
+			r, ok := ctx.Value(requestRecordKey{}).(*requestRecord)
+			if !ok {
+				return err
+			}
+
+			r.mu.Lock()
+			r.trace.Duration = time.Since(r.trace.Start)
+			r.trace.Code = grpc.Code(err).String()
+			r.trace.Error = errString(err)
+			trace := r.trace
+			r.mu.Unlock()
+
+			l.add(trace)
+			return err
+		},
+	}
+}
+
+func recordMessage(ctx context.Context, direction string, m interface{}) {
+	r, ok := ctx.Value(requestRecordKey{}).(*requestRecord)
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.trace.Events) >= maxRequestEvents {
+		r.trace.DroppedEvents++
+		return
+	}
+	r.trace.Events = append(r.trace.Events, MessageEvent{Time: time.Now(), Direction: direction, Size: messageSize(m)})
+}
+
+//metadata copies the incoming metadata, with the values of keys that aren't shown masked
+func (l *RequestLog) metadata(ctx context.Context) map[string][]string {
+	md, ok := metadata.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	copied := map[string][]string{}
+	for k, v := range md {
+		if !l.showMetadata[k] {
+			v = []string{Redacted}
+		}
+		copied[k] = append([]string(nil), v...)
+	}
+	return copied
+}
+
+func (l *RequestLog) add(trace RequestTrace) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if len(l.entries) == 0 {
+		return
+	}
+
+	l.entries[l.next] = trace
+	l.next = (l.next + 1) % len(l.entries)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+//RequestFilter picks which calls Recent returns
+type RequestFilter struct {
+	//Method is a MethodFilter pattern. Empty matches every method.
+	Method string
+	//ErrorsOnly leaves out calls that returned OK
+	ErrorsOnly bool
+	//MinDuration leaves out calls faster than it
+	MinDuration time.Duration
+}
+
+func (f RequestFilter) match(t RequestTrace) bool {
+	if f.Method != "" && !OnlyMethods(f.Method).Match(t.FullMethod) {
+		return false
+	}
+	if f.ErrorsOnly && t.Error == "" {
+		return false
+	}
+	return t.Duration >= f.MinDuration
+}
+
+//Recent returns the calls in the log that match the filter, newest first
+func (l *RequestLog) Recent(f RequestFilter) []RequestTrace {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	n := l.next
+	if l.full {
+		n = len(l.entries)
+	}
+
+	traces := []RequestTrace{}
+	for i := 1; i <= n; i++ {
+		t := l.entries[(l.next-i+len(l.entries))%len(l.entries)]
+		if f.match(t) {
+			traces = append(traces, t)
+		}
+	}
+	return traces
+}
+
+//Handler serves the log as an HTML page, or as JSON with ?format=json. It can be filtered with ?method= (a MethodFilter pattern),
+// ?errors=true and ?min= (a duration like 100ms).
+func (l *RequestLog) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		f := RequestFilter{
+			Method:     q.Get("method"),
+			ErrorsOnly: q.Get("errors") == "true",
+		}
+		if min := q.Get("min"); min != "" {
+			d, err := time.ParseDuration(min)
+			if err != nil {
+				http.Error(w, "bad min duration: "+err.Error(), http.StatusBadRequest)
+				return
+			}
+			f.MinDuration = d
+		}
+
+		traces := l.Recent(f)
+
+		if q.Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(traces)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		requestsPage.Execute(w, struct {
+			Filter  RequestFilter
+			Min     string
+			Methods []string
+			Traces  []RequestTrace
+		}{f, q.Get("min"), l.methods(), traces})
+	})
+}
+
+//methods lists the methods in the log for the filter form
+func (l *RequestLog) methods() []string {
+	seen := map[string]bool{}
+	for _, t := range l.Recent(RequestFilter{}) {
+		seen[t.FullMethod] = true
+	}
+
+	methods := make([]string, 0, len(seen))
+	for m := range seen {
+		methods = append(methods, m)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+var requestsPage = template.Must(template.New("requests").Funcs(template.FuncMap{
+	"since": func(start, t time.Time) time.Duration { return t.Sub(start) },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<title>/debug/requests</title>
+<style>
+body { font-family: sans-serif; font-size: 14px; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 2px 8px; vertical-align: top; }
+tr.error td { color: #b00; }
+details { color: #444; }
+</style>
+</head>
+<body>
+<h1>Recent requests</h1>
+<form method="get">
+Method <select name="method">
+<option value="">all</option>
+{{range .Methods}}<option value="{{.}}"{{if eq . $.Filter.Method}} selected{{end}}>{{.}}</option>{{end}}
+</select>
+<label><input type="checkbox" name="errors" value="true"{{if .Filter.ErrorsOnly}} checked{{end}}> errors only</label>
+Slower than <input type="text" name="min" value="{{.Min}}" placeholder="100ms" size="8">
+<input type="submit" value="Filter">
+<a href="?format=json&method={{.Filter.Method}}&errors={{.Filter.ErrorsOnly}}&min={{.Min}}">JSON</a>
+</form>
+<p>{{len .Traces}} requests, newest first</p>
+<table>
+<tr><th>Start</th><th>Method</th><th>Duration</th><th>Code</th><th>Peer</th><th>Request ID</th><th>Trace ID</th></tr>
+{{range .Traces}}
+<tr{{if .Error}} class="error"{{end}}>
+<td>{{.Start.Format "15:04:05.000000"}}</td>
+<td>{{.FullMethod}}{{if .Stream}} (stream){{end}}</td>
+<td>{{.Duration}}</td>
+<td>{{.Code}}{{if .Error}}: {{.Error}}{{end}}</td>
+<td>{{.Peer}}</td>
+<td>{{.RequestID}}</td>
+<td>{{.TraceID}}</td>
+</tr>
+<tr><td></td><td colspan="6"><details><summary>details</summary>
+{{range $k, $v := .Metadata}}<div>{{$k}}: {{range $v}}{{.}} {{end}}</div>{{end}}
+{{$start := .Start}}
+{{range .Annotations}}<div>+{{since $start .Time}} {{.Message}}</div>{{end}}
+{{range .Events}}<div>+{{since $start .Time}} {{.Direction}} {{.Size}} bytes</div>{{end}}
+{{if .DroppedEvents}}<div>... {{.DroppedEvents}} more messages</div>{{end}}
+</details></td></tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
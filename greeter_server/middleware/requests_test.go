@@ -0,0 +1,186 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+func methodsOf(traces []RequestTrace) []string {
+	var methods []string
+	for _, t := range traces {
+		methods = append(methods, t.FullMethod)
+	}
+	return methods
+}
+
+func TestRequestLogRing(t *testing.T) {
+	l := NewRequestLog(3)
+
+	l.add(RequestTrace{FullMethod: "/a"})
+	l.add(RequestTrace{FullMethod: "/b"})
+	if got, want := methodsOf(l.Recent(RequestFilter{})), []string{"/b", "/a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("before wrapping: got %v, want %v", got, want)
+	}
+	if l.full {
+		t.Error("full before the ring wrapped")
+	}
+
+	l.add(RequestTrace{FullMethod: "/c"})
+	if !l.full {
+		t.Error("not full after size calls")
+	}
+
+	//the oldest calls are overwritten, the newest still come first
+	l.add(RequestTrace{FullMethod: "/d"})
+	l.add(RequestTrace{FullMethod: "/e"})
+	if got, want := methodsOf(l.Recent(RequestFilter{})), []string{"/e", "/d", "/c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after wrapping: got %v, want %v", got, want)
+	}
+}
+
+func TestNewRequestLogSize(t *testing.T) {
+	for _, size := range []int{0, -1} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewRequestLog(%d) didn't panic", size)
+				}
+			}()
+			NewRequestLog(size)
+		}()
+	}
+}
+
+func TestRequestLogFilter(t *testing.T) {
+	l := NewRequestLog(10)
+	l.add(RequestTrace{FullMethod: "/helloworld.Greeter/SayHello", Duration: 10 * time.Millisecond, Code: "OK"})
+	l.add(RequestTrace{FullMethod: "/helloworld.Greeter/SayHelloSlow", Duration: time.Second, Code: "OK"})
+	l.add(RequestTrace{FullMethod: "/helloworld.Greeter/SayHello", Duration: 200 * time.Millisecond, Code: "NotFound", Error: "no"})
+	l.add(RequestTrace{FullMethod: "/other.Service/Call", Duration: time.Millisecond, Code: "Internal", Error: "broken"})
+
+	tests := []struct {
+		name   string
+		filter RequestFilter
+		want   []string
+	}{
+		{"everything", RequestFilter{}, []string{"/other.Service/Call", "/helloworld.Greeter/SayHello", "/helloworld.Greeter/SayHelloSlow", "/helloworld.Greeter/SayHello"}},
+		{"exact method", RequestFilter{Method: "/helloworld.Greeter/SayHello"}, []string{"/helloworld.Greeter/SayHello", "/helloworld.Greeter/SayHello"}},
+		{"method pattern", RequestFilter{Method: "/helloworld.Greeter/*"}, []string{"/helloworld.Greeter/SayHello", "/helloworld.Greeter/SayHelloSlow", "/helloworld.Greeter/SayHello"}},
+		{"errors", RequestFilter{ErrorsOnly: true}, []string{"/other.Service/Call", "/helloworld.Greeter/SayHello"}},
+		{"latency", RequestFilter{MinDuration: 100 * time.Millisecond}, []string{"/helloworld.Greeter/SayHello", "/helloworld.Greeter/SayHelloSlow"}},
+		{"all of them", RequestFilter{Method: "/helloworld.Greeter/*", ErrorsOnly: true, MinDuration: 100 * time.Millisecond}, []string{"/helloworld.Greeter/SayHello"}},
+		{"nothing matches", RequestFilter{Method: "/nope/*"}, nil},
+	}
+
+	for _, test := range tests {
+		if got := methodsOf(l.Recent(test.filter)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRequestLogDroppedEvents(t *testing.T) {
+	const messages = maxRequestEvents + 5
+
+	l := NewRequestLog(1)
+	info := &grpc.StreamServerInfo{FullMethod: testMethod, IsClientStream: true}
+	err := l.Middleware().Stream()(nil, &fakeServerStream{}, info, func(srv interface{}, ss grpc.ServerStream) error {
+		for i := 0; i < messages; i++ {
+			if err := ss.RecvMsg(&loginRequest{}); err != nil {
+				return err
+			}
+		}
+		return grpc.Errorf(codes.Aborted, "done")
+	})
+	if grpc.Code(err) != codes.Aborted {
+		t.Fatalf("err = %v, want the handler's error", err)
+	}
+
+	traces := l.Recent(RequestFilter{})
+	if len(traces) != 1 {
+		t.Fatalf("got %d traces, want 1", len(traces))
+	}
+	trace := traces[0]
+	if len(trace.Events) != maxRequestEvents || trace.DroppedEvents != 5 {
+		t.Errorf("got %d events and %d dropped, want %d and 5", len(trace.Events), trace.DroppedEvents, maxRequestEvents)
+	}
+	if !trace.Stream || trace.Code != "Aborted" {
+		t.Errorf("trace = %+v, want an Aborted stream", trace)
+	}
+}
+
+func TestRequestLogHandler(t *testing.T) {
+	l := NewRequestLog(10)
+	callUnary(context.Background(), l.Middleware().Unary(), testMethod, nil, nil, nil)
+	l.add(RequestTrace{FullMethod: testMethod, Duration: time.Second, Code: "OK"})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		l.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/debug/requests?"+query, nil))
+		return w
+	}
+
+	w := get("format=json&min=500ms")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	var traces []RequestTrace
+	if err := json.Unmarshal(w.Body.Bytes(), &traces); err != nil {
+		t.Fatal(err)
+	}
+	if len(traces) != 1 || traces[0].Duration != time.Second {
+		t.Errorf("got %+v, want only the slow call", traces)
+	}
+
+	w = get("min=soon")
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status = %d for a bad min, want 400", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "bad min duration") {
+		t.Errorf("body = %q, want it to say what was wrong", w.Body.String())
+	}
+
+	w = get("")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), testMethod) {
+		t.Errorf("html page: status %d, body %q", w.Code, w.Body.String())
+	}
+}
+
+func TestRequestLogMetadata(t *testing.T) {
+	md := metadata.Pairs(
+		authKey, "s3cret",
+		"authorization", "Bearer s3cret",
+		"cookie", "session=s3cret",
+		"x-api-key", "s3cret",
+		"user-agent", "grpc-go/1.0",
+		RequestIDKey, "abc",
+		"x-tenant", "acme",
+	)
+	ctx := metadata.NewContext(context.Background(), md)
+
+	l := NewRequestLog(1, "X-Tenant")
+	callUnary(ctx, l.Middleware().Unary(), testMethod, nil, nil, nil)
+
+	want := map[string][]string{
+		authKey:         {Redacted},
+		"authorization": {Redacted},
+		"cookie":        {Redacted},
+		"x-api-key":     {Redacted},
+		"user-agent":    {"grpc-go/1.0"},
+		RequestIDKey:    {"abc"},
+		"x-tenant":      {"acme"},
+	}
+	if got := l.Recent(RequestFilter{})[0].Metadata; !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata = %v, want %v", got, want)
+	}
+}
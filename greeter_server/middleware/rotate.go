@@ -0,0 +1,187 @@
+package middleware
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+//RotatingFile is an io.Writer for log files. The file is rotated once it grows past maxSize bytes or gets older than maxAge,
+//the old one is renamed to path.<timestamp> and only the newest maxBackups of those are kept.
+//Zero values turn off the size limit, the age limit or the cleanup.
+type RotatingFile struct {
+	path       string
+	maxSize    int64
+	maxAge     time.Duration
+	maxBackups int
+
+	mu     sync.Mutex
+	f      *os.File
+	size   int64
+	opened time.Time
+}
+
+//The file system calls that can fail part way through a rotation, swapped out in tests
+var (
+	openFile   = os.OpenFile
+	renameFile = os.Rename
+)
+
+//NewRotatingFile opens or creates the file at path for appending
+func NewRotatingFile(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*RotatingFile, error) {
+	r := &RotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxAge:     maxAge,
+		maxBackups: maxBackups,
+	}
+
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	f, err := openFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	r.f = f
+	r.size = info.Size()
+	r.opened = r.created(info)
+	return nil
+}
+
+//backupTimeFormat is the timestamp added to rotated files' names
+const backupTimeFormat = "20060102T150405.000000000"
+
+//created works out when the file that was just opened was started, so restarting doesn't reset its age.
+//A new or empty file starts now. One that was already there was started by the last rotation, whose time is in the newest
+//backup's name, or if it has never been rotated its last write is the best guess.
+func (r *RotatingFile) created(info os.FileInfo) time.Time {
+	if info.Size() == 0 {
+		return time.Now()
+	}
+
+	if backups := r.backups(); len(backups) > 0 {
+		t, _ := time.Parse(backupTimeFormat, strings.TrimPrefix(backups[len(backups)-1], r.path+"."))
+		return t
+	}
+	return info.ModTime()
+}
+
+//backups lists the rotated files, oldest first. Only names ending in a backup timestamp count, so other files that start
+//with the same name, like access.log.gz or an operator's access.log.old, are left alone.
+func (r *RotatingFile) backups() []string {
+	matches, _ := filepath.Glob(r.path + ".*")
+
+	var backups []string
+	for _, m := range matches {
+		if _, err := time.Parse(backupTimeFormat, strings.TrimPrefix(m, r.path+".")); err == nil {
+			backups = append(backups, m)
+		}
+	}
+	sort.Strings(backups)
+	return backups
+}
+
+//SetLimits changes when the file is rotated and how many old files are kept, starting with the next Write
+func (r *RotatingFile) SetLimits(maxSize int64, maxAge time.Duration, maxBackups int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.maxSize = maxSize
+	r.maxAge = maxAge
+	r.maxBackups = maxBackups
+}
+
+//Write appends p to the file, rotating it first if needed
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	//A failed rotation leaves no file open, try again
+	if r.f == nil {
+		if err := r.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	//If rotating fails but the current file could be reopened, p is still written to it and the rotation is tried again next time
+	var rerr error
+	tooBig := r.maxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.maxSize
+	tooOld := r.maxAge > 0 && time.Since(r.opened) > r.maxAge
+	if tooBig || tooOld {
+		if rerr = r.rotate(); r.f == nil {
+			return 0, rerr
+		}
+	}
+
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	if err == nil {
+		err = rerr
+	}
+	return n, err
+}
+
+//rotate moves the current file out of the way and opens a new one. If the file can't be moved it's reopened so writes carry on
+//going to it, and if no file can be opened r.f is left nil for the next Write to try again.
+func (r *RotatingFile) rotate() error {
+	err := r.f.Close()
+	r.f = nil
+	if err != nil {
+		return err
+	}
+
+	backup := fmt.Sprintf("%s.%s", r.path, time.Now().UTC().Format(backupTimeFormat))
+	if err := renameFile(r.path, backup); err != nil {
+		if oerr := r.open(); oerr != nil {
+			return oerr
+		}
+		return err
+	}
+
+	r.removeOldBackups()
+
+	return r.open()
+}
+
+//removeOldBackups deletes all but the newest maxBackups rotated files. The timestamp suffix sorts oldest first.
+func (r *RotatingFile) removeOldBackups() {
+	if r.maxBackups <= 0 {
+		return
+	}
+
+	backups := r.backups()
+	if len(backups) <= r.maxBackups {
+		return
+	}
+
+	for _, b := range backups[:len(backups)-r.maxBackups] {
+		os.Remove(b)
+	}
+}
+
+//Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.f == nil {
+		return nil
+	}
+	return r.f.Close()
+}
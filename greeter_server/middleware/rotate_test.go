@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func tempLog(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "rotate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "access.log"), func() { os.RemoveAll(dir) }
+}
+
+func backups(t *testing.T, path string) []string {
+	b, err := filepath.Glob(path + ".*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readFile(t *testing.T, path string) string {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRotatingFileSize(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	r, err := NewRotatingFile(path, 10, 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	for _, line := range []string{"aaaaaa\n", "bbbbbb\n", "cccccc\n", "dddddd\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		//backup names are timestamped to the nanosecond, make sure they differ
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := readFile(t, path); got != "dddddd\n" {
+		t.Errorf("current file = %q, want the last line", got)
+	}
+	//three rotations, only the newest two kept
+	if got := backups(t, path); len(got) != 2 {
+		t.Fatalf("got %d backups, want 2: %v", len(got), got)
+	}
+	if got := readFile(t, backups(t, path)[1]); got != "cccccc\n" {
+		t.Errorf("newest backup = %q, want the third line", got)
+	}
+}
+
+func TestRotatingFileKeepsOtherFiles(t *testing.T) {
+	path, cleanup := tempLog(t)
+	defer cleanup()
+
+	others := []string{path + ".keep", path + ".gz", path + ".old"}
+	for _, other := range others {
+		if err := ioutil.WriteFile(other, []byte("mine\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewRotatingFile(path, 5, 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	for _, line := range []string{"one\n", "two\n", "three\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	for _, other := range others {
+		if _, err := os.Stat(other); err != nil {
+			t.Errorf("%s was removed: %v", filepath.Base(other), err)
+		}
+	}
+	if got := len(r.backups()); got != 1 {
+		t.Errorf("got %d backups, want 1", got)
+	}
+}
+
+func TestRotatingFileAgeAfterReopen(t *testing.T) {
+	tests := []struct {
+		name   string
+		setup  func(t *testing.T, path string)
+		rotate bool
+	}{
+		{
+			name:   "new file",
+			setup:  func(t *testing.T, path string) {},
+			rotate: false,
+		},
+		{
+			name: "recently rotated",
+			setup: func(t *testing.T, path string) {
+				ioutil.WriteFile(path, []byte("old\n"), 0644)
+				ioutil.WriteFile(path+"."+time.Now().Add(-time.Minute).UTC().Format(backupTimeFormat), nil, 0644)
+			},
+			rotate: false,
+		},
+		{
+			name: "rotated long ago",
+			setup: func(t *testing.T, path string) {
+				//written to just now, but started by a rotation two hours ago
+				ioutil.WriteFile(path, []byte("old\n"), 0644)
+				ioutil.WriteFile(path+"."+time.Now().Add(-2*time.Hour).UTC().Format(backupTimeFormat), nil, 0644)
+			},
+			rotate: true,
+		},
+		{
+			name: "never rotated, last written long ago",
+			setup: func(t *testing.T, path string) {
+				ioutil.WriteFile(path, []byte("old\n"), 0644)
+				old := time.Now().Add(-2 * time.Hour)
+				if err := os.Chtimes(path, old, old); err != nil {
+					t.Fatal(err)
+				}
+			},
+			rotate: true,
+		},
+		{
+			name: "never rotated, written recently",
+			setup: func(t *testing.T, path string) {
+				ioutil.WriteFile(path, []byte("old\n"), 0644)
+			},
+			rotate: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path, cleanup := tempLog(t)
+			defer cleanup()
+			test.setup(t, path)
+			before := len(backups(t, path))
+
+			r, err := NewRotatingFile(path, 0, time.Hour, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer r.Close()
+			if _, err := r.Write([]byte("new\n")); err != nil {
+				t.Fatal(err)
+			}
+
+			rotated := len(backups(t, path)) > before
+			if rotated != test.rotate {
+				t.Errorf("rotated = %v, want %v", rotated, test.rotate)
+			}
+		})
+	}
+}
+
+func TestRotatingFileRecovers(t *testing.T) {
+	defer func() {
+		openFile = os.OpenFile
+		renameFile = os.Rename
+	}()
+	broken := errors.New("broken")
+
+	t.Run("rename fails", func(t *testing.T) {
+		path, cleanup := tempLog(t)
+		defer cleanup()
+
+		r, err := NewRotatingFile(path, 5, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		r.Write([]byte("one\n"))
+
+		renameFile = func(string, string) error { return broken }
+		n, err := r.Write([]byte("two\n"))
+		if err != broken {
+			t.Errorf("err = %v, want %v", err, broken)
+		}
+		if n != 4 {
+			t.Errorf("wrote %d bytes, want 4 to the old file", n)
+		}
+
+		//the next write rotates
+		renameFile = os.Rename
+		if _, err := r.Write([]byte("three\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, path); got != "three\n" {
+			t.Errorf("current file = %q, want the last line", got)
+		}
+		if b := backups(t, path); len(b) != 1 || readFile(t, b[0]) != "one\ntwo\n" {
+			t.Errorf("backups = %v, want one with the first two lines", b)
+		}
+	})
+
+	t.Run("open fails", func(t *testing.T) {
+		path, cleanup := tempLog(t)
+		defer cleanup()
+
+		r, err := NewRotatingFile(path, 5, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		r.Write([]byte("one\n"))
+
+		openFile = func(string, int, os.FileMode) (*os.File, error) { return nil, broken }
+		if _, err := r.Write([]byte("two\n")); err != broken {
+			t.Errorf("err = %v, want %v", err, broken)
+		}
+		if _, err := r.Write([]byte("two\n")); err != broken {
+			t.Errorf("err = %v, want %v while the file can't be opened", err, broken)
+		}
+
+		openFile = os.OpenFile
+		if _, err := r.Write([]byte("three\n")); err != nil {
+			t.Fatal(err)
+		}
+		if got := readFile(t, path); got != "three\n" {
+			t.Errorf("current file = %q, want the line written once it could be opened", got)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package middleware
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//WithSampling cuts down on log lines for busy methods. Only 1 in everyN successful calls to a method is logged and no more
+//than maxPerSecond lines are written each second; calls that fail are always logged. A count of the lines that were left out
+//is logged every reportEvery while lines are being left out. everyN <= 1 logs every call, maxPerSecond <= 0 means no cap and
+//reportEvery <= 0 reports every 10s.
+//Give the same option to NewUnaryLogging and NewStreamLogging to have them share the counts and the cap.
+func WithSampling(everyN, maxPerSecond int, reportEvery time.Duration) LoggingOption {
+	s := newSampler(everyN, maxPerSecond, reportEvery)
+	return func(o *loggingOptions) {
+		o.sampler = s
+	}
+}
+
+//sampler is shared by every call going through the logging interceptors it was given to. A nil sampler lets everything through.
+type sampler struct {
+	everyN       int64
+	maxPerSecond int
+	reportEvery  time.Duration
+
+	mu         sync.Mutex
+	logger     Logger
+	calls      map[string]int64
+	second     int64
+	lines      int
+	suppressed map[string]int64
+	lastReport time.Time
+	reporting  bool
+}
+
+func newSampler(everyN, maxPerSecond int, reportEvery time.Duration) *sampler {
+	if reportEvery <= 0 {
+		reportEvery = 10 * time.Second
+	}
+	return &sampler{
+		everyN:       int64(everyN),
+		maxPerSecond: maxPerSecond,
+		reportEvery:  reportEvery,
+		calls:        map[string]int64{},
+		suppressed:   map[string]int64{},
+		lastReport:   time.Now(),
+	}
+}
+
+//setLogger sets where reports go, the logger of the first interceptor the sampler was given to
+func (s *sampler) setLogger(logger Logger) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.logger == nil {
+		s.logger = logger
+	}
+}
+
+//sampleCall decides whether a call to fullMethod is logged if it succeeds
+func (s *sampler) sampleCall(fullMethod string) bool {
+	if s == nil || s.everyN <= 1 {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := s.calls[fullMethod]
+	s.calls[fullMethod] = n + 1
+	return n%s.everyN == 0
+}
+
+//allowLine decides whether a line for fullMethod is written. Lines for sampled calls are still subject to the per second cap,
+//lines about errors always go through. Lines that don't are counted for the next report.
+func (s *sampler) allowLine(fullMethod string, sampled, isErr bool) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().Unix()
+	if now != s.second {
+		s.second = now
+		s.lines = 0
+	}
+
+	allow := isErr || sampled && (s.maxPerSecond <= 0 || s.lines < s.maxPerSecond)
+	if allow {
+		s.lines++
+	} else {
+		s.suppressed[fullMethod]++
+		if !s.reporting && s.logger != nil {
+			s.reporting = true
+			go s.reportLoop()
+		}
+	}
+	return allow
+}
+
+//reportLoop reports every reportEvery until there's nothing left to report, so it only runs while lines are being left out
+//and doesn't outlive an interceptor that's been swapped out by a config reload
+func (s *sampler) reportLoop() {
+	ticker := time.NewTicker(s.reportEvery)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if !s.report() {
+			return
+		}
+	}
+}
+
+//report logs how many lines were left out per method since the last report. It returns false, and stops the report loop,
+//if there weren't any.
+func (s *sampler) report() bool {
+	s.mu.Lock()
+	if len(s.suppressed) == 0 {
+		s.reporting = false
+		s.mu.Unlock()
+		return false
+	}
+	suppressed := s.suppressed
+	since := s.lastReport
+	logger := s.logger
+	s.suppressed = map[string]int64{}
+	s.lastReport = time.Now()
+	s.mu.Unlock()
+
+	methods := make([]string, 0, len(suppressed))
+	for method := range suppressed {
+		methods = append(methods, method)
+	}
+	sort.Strings(methods)
+
+	fields := []Field{{"since", since.String()}}
+	for _, method := range methods {
+		fields = append(fields, Field{method, strconv.FormatInt(suppressed[method], 10)})
+	}
+	logger.Warn(context.Background(), "suppressed log lines", fields...)
+	return true
+}
@@ -0,0 +1,172 @@
+package middleware
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+func TestLoggingSampling(t *testing.T) {
+	failed := grpc.Errorf(codes.Internal, "broke")
+
+	tests := []struct {
+		name         string
+		everyN       int
+		maxPerSecond int
+		calls        []error
+		wantLines    int
+		wantErrors   int
+	}{
+		{"no sampling", 1, 0, []error{nil, nil, nil}, 6, 0},
+		{"1 in 3", 3, 0, []error{nil, nil, nil, nil, nil, nil, nil}, 6, 0},
+		{"errors always logged", 3, 0, []error{nil, failed, failed, nil, failed}, 7, 3},
+		{"per second cap", 1, 3, []error{nil, nil, nil, nil, nil}, 3, 0},
+		{"errors skip the cap", 1, 2, []error{nil, nil, failed, failed}, 4, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//The cap is per wall clock second, so start over if the calls didn't all happen in the same one
+			for {
+				rec := NewRecorder()
+				interceptor := NewUnaryLogging(rec, WithSampling(test.everyN, test.maxPerSecond, time.Hour))
+
+				second := time.Now().Unix()
+				for _, err := range test.calls {
+					callUnary(context.Background(), interceptor, testMethod, nil, nil, err)
+				}
+				if time.Now().Unix() != second {
+					continue
+				}
+
+				entries := rec.Entries()
+				if len(entries) != test.wantLines {
+					t.Errorf("got %d lines, want %d", len(entries), test.wantLines)
+				}
+				var errs int
+				for _, e := range entries {
+					if e.Level == LevelError {
+						errs++
+					}
+				}
+				if errs != test.wantErrors {
+					t.Errorf("got %d error lines, want %d", errs, test.wantErrors)
+				}
+				return
+			}
+		})
+	}
+}
+
+//waitForReport waits for the sampler's report to be logged
+func waitForReport(t *testing.T, rec *Recorder) Entry {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		for _, e := range rec.Entries() {
+			if e.Msg == "suppressed log lines" {
+				return e
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no report in %v", rec.Entries())
+	return Entry{}
+}
+
+func TestLoggingSamplingReport(t *testing.T) {
+	rec := NewRecorder()
+	opt := WithSampling(2, 0, 10*time.Millisecond)
+	interceptor := NewUnaryLogging(rec, opt)
+
+	for i := 0; i < 3; i++ {
+		callUnary(context.Background(), interceptor, testMethod, nil, nil, nil)
+	}
+
+	//reported from the ticker, without waiting for another call
+	report := waitForReport(t, rec)
+	if report.Level != LevelWarn {
+		t.Errorf("report level = %s, want WARN", report.Level)
+	}
+	//the second call's start and end lines
+	if got := report.Field(testMethod); got != "2" {
+		t.Errorf("suppressed %s = %q, want 2", testMethod, got)
+	}
+
+	//once there's nothing left to report the loop stops
+	o := loggingOptions{}
+	opt(&o)
+	s := o.sampler
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		s.mu.Lock()
+		reporting := s.reporting
+		s.mu.Unlock()
+		if !reporting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the report loop is still running with nothing to report")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if n := len(rec.Entries()); n != 5 {
+		t.Errorf("got %d lines, want 4 and the report", n)
+	}
+}
+
+func TestLoggingSamplingShared(t *testing.T) {
+	rec := NewRecorder()
+	opt := WithSampling(1, 3, time.Hour)
+	unary := NewUnaryLogging(rec, opt)
+	stream := NewStreamLogging(rec, opt)
+
+	//The cap is per wall clock second, so start over if the calls didn't all happen in the same one
+	for {
+		rec.Reset()
+		second := time.Now().Unix()
+		callUnary(context.Background(), unary, testMethod, nil, nil, nil)
+		callStream(context.Background(), stream, testMethod, &loginRequest{}, nil)
+		if time.Now().Unix() == second {
+			break
+		}
+		time.Sleep(time.Until(time.Unix(second+1, 0)))
+	}
+
+	//2 unary lines and only the stream's start line fit under the shared cap of 3
+	entries := rec.Entries()
+	if len(entries) != 3 {
+		t.Fatalf("got %d lines, want 3: %v", len(entries), entries)
+	}
+	if entries[2].Msg != "stream started" {
+		t.Errorf("last line = %q, want stream started", entries[2].Msg)
+	}
+}
+
+func TestLoggingParamsShareSampler(t *testing.T) {
+	p := Params{"sampleEvery": "10", "maxPerSecond": "5", "reportEvery": "1m"}
+	unaryOpts, err := loggingParams(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	streamOpts, err := loggingParams(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherOpts, err := loggingParams(Params{"sampleEvery": "10"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unary := newLoggingOptions(NewRecorder(), unaryOpts)
+	stream := newLoggingOptions(NewRecorder(), streamOpts)
+	other := newLoggingOptions(NewRecorder(), otherOpts)
+	if unary.sampler == nil || unary.sampler != stream.sampler {
+		t.Error("entries with the same sampling params don't share a sampler")
+	}
+	if other.sampler == unary.sampler {
+		t.Error("entries with different sampling params share a sampler")
+	}
+}
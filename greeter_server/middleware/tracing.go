@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"io"
+	"strconv"
+	"sync/atomic"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//UnaryTracing starts a server span for unary calls, continuing the trace in the client's traceparent header if there is one.
+//The span is in the ctx the handler gets, see tracing.SpanFromContext. Spans go to tracing.DefaultTracer.
+func UnaryTracing(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	return traceUnary(tracing.DefaultTracer, ctx, req, info, handler)
+}
+
+//NewUnaryTracing creates a UnaryTracing interceptor that sends spans to t
+func NewUnaryTracing(t *tracing.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		return traceUnary(t, ctx, req, info, handler)
+	}
+}
+
+func traceUnary(t *tracing.Tracer, ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	ctx, span := startServerSpan(t, ctx, info.FullMethod)
+	defer span.End()
+
+	resp, err = handler(ctx, req)
+
+	span.SetStatus(err)
+	return resp, err
+}
+
+//StreamTracing starts a server span for streaming calls like UnaryTracing does, plus a child span for every message received and sent
+func StreamTracing(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	return traceStream(tracing.DefaultTracer, srv, ss, info, handler)
+}
+
+//NewStreamTracing creates a StreamTracing interceptor that sends spans to t
+func NewStreamTracing(t *tracing.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return traceStream(t, srv, ss, info, handler)
+	}
+}
+
+func traceStream(t *tracing.Tracer, srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, span := startServerSpan(t, ss.Context(), info.FullMethod)
+	defer span.End()
+
+	var received, sent int64
+	newStream := wrapServerStream(WithStreamContext(ss, ctx))
+	newStream.RegisterRecvMiddleware(messageSpans(t, ctx, info.FullMethod+" recv", &received))
+	newStream.RegisterSendMiddleware(messageSpans(t, ctx, info.FullMethod+" send", &sent))
+
+	err := handler(srv, newStream)
+
+	span.SetAttribute("messages.received", strconv.FormatInt(atomic.LoadInt64(&received), 10))
+	span.SetAttribute("messages.sent", strconv.FormatInt(atomic.LoadInt64(&sent), 10))
+	span.SetStatus(err)
+	return err
+}
+
+//startServerSpan continues the trace from the incoming metadata, or starts a new one
+func startServerSpan(t *tracing.Tracer, ctx context.Context, fullMethod string) (context.Context, *tracing.Span) {
+	if md, ok := metadata.FromContext(ctx); ok {
+		if parent, ok := tracing.Extract(md); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+	}
+
+	ctx, span := t.Start(ctx, fullMethod, tracing.KindServer)
+	span.SetAttribute("rpc.system", "grpc")
+	span.SetAttribute("rpc.method", fullMethod)
+	if addr := peerAddress(ctx); addr != "" {
+		span.SetAttribute("net.peer.address", addr)
+	}
+	if id := RequestIDFromContext(ctx); id != "" {
+		span.SetAttribute("request.id", id)
+	}
+	return ctx, span
+}
+
+//messageSpans times each message in a child span of the stream's span. The stream ending (io.EOF) isn't an error.
+func messageSpans(t *tracing.Tracer, ctx context.Context, name string, count *int64) func(StreamHandler) StreamHandler {
+	return func(inner StreamHandler) StreamHandler {
+		return StreamFunc(func(m interface{}) error {
+			_, span := t.Start(ctx, name, tracing.KindInternal)
+			defer span.End()
+
+			err := inner.Stream(m)
+			if err == io.EOF {
+				span.SetAttribute("message.eof", "true")
+				return err
+			}
+			if err != nil {
+				span.SetStatus(err)
+				return err
+			}
+
+			span.SetAttribute("message.id", strconv.FormatInt(atomic.AddInt64(count, 1), 10))
+			return nil
+		})
+	}
+}
+
+//tracedLogger adds the trace and span IDs in ctx to every line, so logs can be matched up with traces
+type tracedLogger struct {
+	Logger
+}
+
+func withTraceIDs(logger Logger) Logger {
+	if _, ok := logger.(tracedLogger); ok {
+		return logger
+	}
+	return tracedLogger{logger}
+}
+
+func (l tracedLogger) Info(ctx context.Context, msg string, fields ...Field) {
+	l.Logger.Info(ctx, msg, traceFields(ctx, fields)...)
+}
+
+func (l tracedLogger) Warn(ctx context.Context, msg string, fields ...Field) {
+	l.Logger.Warn(ctx, msg, traceFields(ctx, fields)...)
+}
+
+func (l tracedLogger) Error(ctx context.Context, msg string, fields ...Field) {
+	l.Logger.Error(ctx, msg, traceFields(ctx, fields)...)
+}
+
+func traceFields(ctx context.Context, fields []Field) []Field {
+	sc, ok := tracing.SpanContextFromContext(ctx)
+	if !ok {
+		return fields
+	}
+	return append(fields, Field{"traceID", sc.TraceID.String()}, Field{"spanID", sc.SpanID.String()})
+}
@@ -0,0 +1,197 @@
+package middleware
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	client "github.com/troylelandshields/helloworld_grpctooling_poc/greeter_client/middleware"
+	pb "github.com/troylelandshields/helloworld_grpctooling_poc/helloworld"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+//spanRecorder is a tracing.Exporter that keeps the spans it's given
+type spanRecorder struct {
+	mu    sync.Mutex
+	spans []tracing.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(data tracing.SpanData) {
+	r.mu.Lock()
+	r.spans = append(r.spans, data)
+	r.mu.Unlock()
+}
+
+//trace returns the spans of one trace by name
+func (r *spanRecorder) trace(id tracing.TraceID) map[string][]tracing.SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spans := map[string][]tracing.SpanData{}
+	for _, s := range r.spans {
+		if s.Context.TraceID == id {
+			spans[s.Name] = append(spans[s.Name], s)
+		}
+	}
+	return spans
+}
+
+func attribute(s tracing.SpanData, key string) string {
+	for _, a := range s.Attributes {
+		if a.Key == key {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+//tracedGreeter answers SayHello and echoes every SayHelloToMany request, and remembers the span its handlers ran in
+type tracedGreeter struct {
+	pb.GreeterServer
+	spans chan tracing.SpanContext
+}
+
+func (g tracedGreeter) SayHello(ctx context.Context, req *pb.HelloRequest) (*pb.HelloReply, error) {
+	g.spans <- tracing.SpanFromContext(ctx).Context()
+	return &pb.HelloReply{Message: "Hello " + req.Name}, nil
+}
+
+func (g tracedGreeter) SayHelloToMany(stream pb.Greeter_SayHelloToManyServer) error {
+	g.spans <- tracing.SpanFromContext(stream.Context()).Context()
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(&pb.HelloReply{Message: "Hello " + req.Name}); err != nil {
+			return err
+		}
+	}
+}
+
+//TestTracingClientToServer makes calls through the client and server tracing middleware over an in-process connection
+//and checks the server continues the client's trace
+func TestTracingClientToServer(t *testing.T) {
+	serverSpans := &spanRecorder{}
+	clientSpans := &spanRecorder{}
+	//the client middleware always uses the default tracer
+	tracing.DefaultTracer.AddExporter(clientSpans)
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(NewUnaryTracing(tracing.NewTracer(serverSpans))),
+		grpc.StreamInterceptor(NewStreamTracing(tracing.NewTracer(serverSpans))),
+	)
+	g := tracedGreeter{spans: make(chan tracing.SpanContext, 2)}
+	pb.RegisterGreeterServer(s, g)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithInsecure(),
+		grpc.WithDialer(func(string, time.Duration) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithUnaryInterceptor(client.UnaryTracing),
+		grpc.WithStreamInterceptor(client.StreamTracing),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := pb.NewGreeterClient(conn)
+
+	t.Run("unary", func(t *testing.T) {
+		if _, err := c.SayHello(context.Background(), &pb.HelloRequest{Name: "world"}); err != nil {
+			t.Fatal(err)
+		}
+		handler := <-g.spans
+
+		spans := serverSpans.trace(handler.TraceID)
+		server := spans["/helloworld.Greeter/SayHello"]
+		if len(server) != 1 || server[0].Kind != tracing.KindServer || server[0].Context.SpanID != handler.SpanID {
+			t.Fatalf("server spans = %+v, want the one the handler ran in", server)
+		}
+		clientSide := clientSpans.trace(handler.TraceID)["/helloworld.Greeter/SayHello"]
+		if len(clientSide) != 1 || clientSide[0].Kind != tracing.KindClient {
+			t.Fatalf("client spans = %+v, want one in the server's trace", clientSide)
+		}
+		if server[0].ParentSpanID != clientSide[0].Context.SpanID {
+			t.Errorf("server span's parent = %s, want the client span %s", server[0].ParentSpanID, clientSide[0].Context.SpanID)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		stream, err := c.SayHelloToMany(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"a", "b", "c"} {
+			if err := stream.Send(&pb.HelloRequest{Name: name}); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := stream.Recv(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		stream.CloseSend()
+		if _, err := stream.Recv(); err != io.EOF {
+			t.Fatalf("err = %v, want io.EOF", err)
+		}
+		handler := <-g.spans
+
+		const method = "/helloworld.Greeter/SayHelloToMany"
+		//the server span ends once the handler returns, which can be just after the client sees io.EOF
+		var server map[string][]tracing.SpanData
+		for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+			server = serverSpans.trace(handler.TraceID)
+			if len(server[method]) == 1 || time.Now().After(deadline) {
+				break
+			}
+		}
+		if len(server[method]) != 1 {
+			t.Fatalf("server spans = %+v", server)
+		}
+		stream0 := server[method][0]
+		if stream0.Context.SpanID != handler.SpanID {
+			t.Errorf("server span isn't the one the handler ran in")
+		}
+		if attribute(stream0, "messages.received") != "3" || attribute(stream0, "messages.sent") != "3" {
+			t.Errorf("server span attributes = %v, want 3 messages each way", stream0.Attributes)
+		}
+
+		//every message gets a child span of the stream's span, and the last receive sees the client close its side
+		recv, send := server[method+" recv"], server[method+" send"]
+		if len(recv) != 4 || len(send) != 3 {
+			t.Fatalf("got %d recv and %d send spans, want 4 and 3", len(recv), len(send))
+		}
+		for _, s := range append(recv, send...) {
+			if s.ParentSpanID != handler.SpanID {
+				t.Errorf("%s span's parent = %s, want the stream's span", s.Name, s.ParentSpanID)
+			}
+		}
+		if attribute(recv[0], "message.id") != "1" || attribute(recv[3], "message.eof") != "true" {
+			t.Errorf("recv spans = %+v, want ids and the end of the stream", recv)
+		}
+
+		clientSide := clientSpans.trace(handler.TraceID)
+		if len(clientSide[method]) != 1 {
+			t.Fatalf("client spans = %+v, want the stream's span", clientSide)
+		}
+		if stream0.ParentSpanID != clientSide[method][0].Context.SpanID {
+			t.Errorf("server span's parent = %s, want the client span", stream0.ParentSpanID)
+		}
+		if attribute(clientSide[method][0], "messages.sent") != "3" || attribute(clientSide[method][0], "messages.received") != "3" {
+			t.Errorf("client span attributes = %v, want 3 messages each way", clientSide[method][0].Attributes)
+		}
+		if len(clientSide[method+" send"]) != 3 || len(clientSide[method+" recv"]) != 4 {
+			t.Errorf("got %d client send and %d recv spans, want 3 and 4", len(clientSide[method+" send"]), len(clientSide[method+" recv"]))
+		}
+	})
+}
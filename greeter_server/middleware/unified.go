@@ -0,0 +1,159 @@
+package middleware
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//CallInfo describes the call a Middleware is running for
+type CallInfo struct {
+	FullMethod     string
+	IsClientStream bool
+	IsServerStream bool
+}
+
+//Middleware is written once and can be turned into both a unary and a streaming interceptor.
+//Every hook is optional.
+type Middleware struct {
+	//Name is what the middleware is listed as when the server's chain is described
+	Name string
+
+	//Before is called before the handler. The returned context is the one the handler sees; returning an error rejects the call
+	//without running the handler, and After is called with that error.
+	Before func(ctx context.Context, info *CallInfo) (context.Context, error)
+
+	//After is called once the handler is done with the error it returned. The error After returns is the one sent to the client.
+	After func(ctx context.Context, info *CallInfo, err error) error
+
+	//OnRecv is called for every message received from the client. For unary calls that's the request.
+	OnRecv func(ctx context.Context, info *CallInfo, m interface{}) error
+
+	//OnSend is called for every message before it is sent to the client. For unary calls that's the response.
+	OnSend func(ctx context.Context, info *CallInfo, m interface{}) error
+
+	//Methods limits which methods the middleware runs for. The zero value runs it for all of them.
+	Methods MethodFilter
+}
+
+//Unary turns the middleware into an interceptor for unary endpoints
+func (mw Middleware) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		if !mw.Methods.Match(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		call := &CallInfo{FullMethod: info.FullMethod}
+
+		newCtx, err := mw.before(ctx, call)
+		if err != nil {
+			return nil, mw.after(ctx, call, err)
+		}
+		ctx = newCtx
+
+		err = mw.recv(ctx, call, req)
+		if err == nil {
+			resp, err = handler(ctx, req)
+		}
+		if err == nil {
+			err = mw.send(ctx, call, resp)
+		}
+
+		err = mw.after(ctx, call, err)
+		if err != nil {
+			return nil, err
+		}
+
+		return resp, nil
+	}
+}
+
+//Stream turns the middleware into an interceptor for streaming endpoints
+func (mw Middleware) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if !mw.Methods.Match(info.FullMethod) {
+			return handler(srv, ss)
+		}
+
+		call := &CallInfo{
+			FullMethod:     info.FullMethod,
+			IsClientStream: info.IsClientStream,
+			IsServerStream: info.IsServerStream,
+		}
+
+		newStream := wrapServerStream(ss)
+
+		ctx, err := mw.before(context.WithValue(ss.Context(), serverStreamKey{}, newStream), call)
+		if err != nil {
+			return mw.after(ss.Context(), call, err)
+		}
+		newStream.WrappedContext = ctx
+
+		if mw.OnRecv != nil {
+			newStream.RegisterRecvMiddleware(func(inner StreamHandler) StreamHandler {
+				return StreamFunc(func(m interface{}) error {
+					if err := inner.Stream(m); err != nil {
+						return err
+					}
+
+					return mw.OnRecv(ctx, call, m)
+				})
+			})
+		}
+
+		if mw.OnSend != nil {
+			newStream.RegisterSendMiddleware(func(inner StreamHandler) StreamHandler {
+				return StreamFunc(func(m interface{}) error {
+					if err := mw.OnSend(ctx, call, m); err != nil {
+						return err
+					}
+
+					return inner.Stream(m)
+				})
+			})
+		}
+
+		err = handler(srv, newStream)
+
+		return mw.after(ctx, call, err)
+	}
+}
+
+type serverStreamKey struct{}
+
+//SetHeader sets header metadata for the call in ctx, like grpc.SetHeader. In a stream's Before hook the header goes through
+//the wrapped stream, so the SetHeader middleware registered on it sees it as well.
+func SetHeader(ctx context.Context, md metadata.MD) error {
+	if ss, ok := ctx.Value(serverStreamKey{}).(*wrappedServerStream); ok {
+		return ss.SetHeader(md)
+	}
+	return grpc.SetHeader(ctx, md)
+}
+
+func (mw Middleware) before(ctx context.Context, call *CallInfo) (context.Context, error) {
+	if mw.Before == nil {
+		return ctx, nil
+	}
+	return mw.Before(ctx, call)
+}
+
+func (mw Middleware) after(ctx context.Context, call *CallInfo, err error) error {
+	if mw.After == nil {
+		return err
+	}
+	return mw.After(ctx, call, err)
+}
+
+func (mw Middleware) recv(ctx context.Context, call *CallInfo, m interface{}) error {
+	if mw.OnRecv == nil {
+		return nil
+	}
+	return mw.OnRecv(ctx, call, m)
+}
+
+func (mw Middleware) send(ctx context.Context, call *CallInfo, m interface{}) error {
+	if mw.OnSend == nil {
+		return nil
+	}
+	return mw.OnSend(ctx, call, m)
+}
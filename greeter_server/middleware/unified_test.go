@@ -0,0 +1,247 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+type orderKey struct{}
+
+//recordingMiddleware appends every hook it runs to calls, and checks the context Before returned reaches the others
+func recordingMiddleware(calls *[]string) Middleware {
+	check := func(ctx context.Context, hook string) {
+		if ctx.Value(orderKey{}) == nil {
+			*calls = append(*calls, hook+" without Before's context")
+		}
+	}
+
+	return Middleware{
+		Before: func(ctx context.Context, info *CallInfo) (context.Context, error) {
+			*calls = append(*calls, "before")
+			return context.WithValue(ctx, orderKey{}, true), nil
+		},
+		OnRecv: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			check(ctx, "recv")
+			*calls = append(*calls, fmt.Sprint("recv ", m))
+			return nil
+		},
+		OnSend: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			check(ctx, "send")
+			*calls = append(*calls, fmt.Sprint("send ", m))
+			return nil
+		},
+		After: func(ctx context.Context, info *CallInfo, err error) error {
+			check(ctx, "after")
+			*calls = append(*calls, fmt.Sprint("after ", err))
+			return err
+		},
+	}
+}
+
+func TestMiddlewareUnaryOrder(t *testing.T) {
+	var calls []string
+	interceptor := recordingMiddleware(&calls).Unary()
+
+	resp, err := interceptor(context.Background(), "req", &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		if ctx.Value(orderKey{}) == nil {
+			calls = append(calls, "handler without Before's context")
+		}
+		calls = append(calls, "handler")
+		return "resp", nil
+	})
+	if err != nil || resp != "resp" {
+		t.Fatalf("got %v, %v", resp, err)
+	}
+
+	want := []string{"before", "recv req", "handler", "send resp", "after <nil>"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestMiddlewareStreamOrder(t *testing.T) {
+	var calls []string
+	interceptor := recordingMiddleware(&calls).Stream()
+
+	info := &grpc.StreamServerInfo{FullMethod: streamMethod, IsClientStream: true, IsServerStream: true}
+	ss := &fakeServerStream{}
+	err := interceptor(nil, ss, info, func(srv interface{}, stream grpc.ServerStream) error {
+		if stream.Context().Value(orderKey{}) == nil {
+			calls = append(calls, "handler without Before's context")
+		}
+		for i := 1; i <= 2; i++ {
+			stream.RecvMsg(i)
+			stream.SendMsg(i * 10)
+		}
+		return stream.SendMsg(30)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//every message goes through OnRecv and OnSend, not just the first
+	want := []string{"before", "recv 1", "send 10", "recv 2", "send 20", "send 30", "after <nil>"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+	if ss.recvd != 2 || ss.sent != 3 {
+		t.Errorf("stream got %d receives and %d sends, want 2 and 3", ss.recvd, ss.sent)
+	}
+}
+
+//rejectingMiddleware fails the call in Before and wraps the error it's given in After
+func rejectingMiddleware(afterErr *error) Middleware {
+	return Middleware{
+		Before: func(ctx context.Context, info *CallInfo) (context.Context, error) {
+			return nil, grpc.Errorf(codes.PermissionDenied, "not allowed")
+		},
+		After: func(ctx context.Context, info *CallInfo, err error) error {
+			if ctx == nil {
+				return errors.New("After got a nil context")
+			}
+			*afterErr = err
+			return grpc.Errorf(grpc.Code(err), "rejected: %s", grpc.ErrorDesc(err))
+		},
+	}
+}
+
+func TestMiddlewareBeforeError(t *testing.T) {
+	t.Run("unary", func(t *testing.T) {
+		var afterErr error
+		handled := false
+		_, err := rejectingMiddleware(&afterErr).Unary()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			handled = true
+			return nil, nil
+		})
+
+		if handled {
+			t.Error("handler ran after Before failed")
+		}
+		if grpc.Code(afterErr) != codes.PermissionDenied {
+			t.Errorf("After got %v, want Before's error", afterErr)
+		}
+		if grpc.ErrorDesc(err) != "rejected: not allowed" {
+			t.Errorf("err = %v, want the one After returned", err)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		var afterErr error
+		handled := false
+		err := rejectingMiddleware(&afterErr).Stream()(nil, &fakeServerStream{}, &grpc.StreamServerInfo{FullMethod: streamMethod}, func(srv interface{}, stream grpc.ServerStream) error {
+			handled = true
+			return nil
+		})
+
+		if handled {
+			t.Error("handler ran after Before failed")
+		}
+		if grpc.Code(afterErr) != codes.PermissionDenied {
+			t.Errorf("After got %v, want Before's error", afterErr)
+		}
+		if grpc.ErrorDesc(err) != "rejected: not allowed" {
+			t.Errorf("err = %v, want the one After returned", err)
+		}
+	})
+}
+
+func TestMiddlewareOnRecvError(t *testing.T) {
+	recvErr := grpc.Errorf(codes.InvalidArgument, "bad message")
+	var afterErr error
+	mw := Middleware{
+		OnRecv: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			if m == 2 {
+				return recvErr
+			}
+			return nil
+		},
+		After: func(ctx context.Context, info *CallInfo, err error) error {
+			afterErr = err
+			return err
+		},
+	}
+
+	t.Run("unary", func(t *testing.T) {
+		afterErr = nil
+		handled := false
+		_, err := mw.Unary()(context.Background(), 2, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+			handled = true
+			return nil, nil
+		})
+
+		//a request OnRecv refuses never gets to the handler
+		if handled {
+			t.Error("handler ran after OnRecv failed")
+		}
+		if err != recvErr || afterErr != recvErr {
+			t.Errorf("err = %v, After got %v, want OnRecv's error for both", err, afterErr)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		afterErr = nil
+		var recvErrs []error
+		err := mw.Stream()(nil, &fakeServerStream{}, &grpc.StreamServerInfo{FullMethod: streamMethod, IsClientStream: true}, func(srv interface{}, stream grpc.ServerStream) error {
+			for i := 1; i <= 3; i++ {
+				recvErrs = append(recvErrs, stream.RecvMsg(i))
+			}
+			return recvErrs[1]
+		})
+
+		//the handler sees the error from RecvMsg and decides what to do with it, here it gives up
+		if want := []error{nil, recvErr, nil}; !reflect.DeepEqual(recvErrs, want) {
+			t.Errorf("RecvMsg returned %v, want %v", recvErrs, want)
+		}
+		if err != recvErr || afterErr != recvErr {
+			t.Errorf("err = %v, After got %v, want OnRecv's error for both", err, afterErr)
+		}
+	})
+}
+
+func TestMiddlewareOnSendError(t *testing.T) {
+	sendErr := grpc.Errorf(codes.Internal, "can't send that")
+	mw := Middleware{
+		OnSend: func(ctx context.Context, info *CallInfo, m interface{}) error {
+			return sendErr
+		},
+	}
+
+	_, err := mw.Unary()(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: testMethod}, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "resp", nil
+	})
+	if err != sendErr {
+		t.Errorf("unary err = %v, want OnSend's error", err)
+	}
+
+	//a message OnSend refuses isn't sent
+	ss := &fakeServerStream{}
+	err = mw.Stream()(nil, ss, &grpc.StreamServerInfo{FullMethod: streamMethod, IsServerStream: true}, func(srv interface{}, stream grpc.ServerStream) error {
+		return stream.SendMsg("resp")
+	})
+	if err != sendErr || ss.sent != 0 {
+		t.Errorf("stream err = %v with %d sent, want OnSend's error and nothing sent", err, ss.sent)
+	}
+}
+
+func TestMiddlewareMethods(t *testing.T) {
+	var calls []string
+	mw := recordingMiddleware(&calls)
+	mw.Methods = OnlyMethods(streamMethod)
+
+	callUnary(context.Background(), mw.Unary(), testMethod, nil, nil, nil)
+	callStream(context.Background(), mw.Stream(), testMethod, nil, nil)
+	if len(calls) != 0 {
+		t.Errorf("calls = %q for a method that isn't matched, want none", calls)
+	}
+
+	callStream(context.Background(), mw.Stream(), streamMethod, nil, nil)
+	if len(calls) == 0 {
+		t.Error("middleware didn't run for a matched method")
+	}
+}
@@ -0,0 +1,39 @@
+//Package wlogger adapts a wlog logger to the middleware's Logger interface, so the middleware package itself doesn't depend on wlog
+package wlogger
+
+import (
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware"
+	"github.com/weave-lab/wlib/wlog"
+	"github.com/weave-lab/wlib/wlog/tag"
+
+	"golang.org/x/net/context"
+)
+
+//New adapts l to middleware.Logger
+func New(l *wlog.WLogger) middleware.Logger {
+	return logger{l}
+}
+
+type logger struct {
+	l *wlog.WLogger
+}
+
+func (w logger) Info(ctx context.Context, msg string, fields ...middleware.Field) {
+	w.l.InfoC(ctx, msg, tags(fields)...)
+}
+
+func (w logger) Warn(ctx context.Context, msg string, fields ...middleware.Field) {
+	w.l.WarnC(ctx, msg, tags(fields)...)
+}
+
+func (w logger) Error(ctx context.Context, msg string, fields ...middleware.Field) {
+	w.l.ErrorC(ctx, msg, tags(fields)...)
+}
+
+func tags(fields []middleware.Field) []tag.Tag {
+	t := make([]tag.Tag, 0, len(fields))
+	for _, f := range fields {
+		t = append(t, tag.String(f.Key, f.Value))
+	}
+	return t
+}
@@ -3,9 +3,12 @@ package middleware
 import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
 )
 
-//Wraps the server stream that is used for sending/receiving messages
+//Wraps the server stream that is used for sending/receiving messages.
+//Each middleware chain is built the first time it's used after a registration and then reused for every message,
+//so sending and receiving doesn't allocate anything on top of what the middleware itself does.
 type wrappedServerStream struct {
 	grpc.ServerStream
 	WrappedContext    context.Context
@@ -13,6 +16,13 @@ type wrappedServerStream struct {
 	sendMsgDispatch   StreamHandler
 	recvMsgMiddleware []func(StreamHandler) StreamHandler
 	sendMsgMiddleware []func(StreamHandler) StreamHandler
+
+	setHeaderDispatch    MetadataHandler
+	sendHeaderDispatch   MetadataHandler
+	setTrailerDispatch   MetadataHandler
+	setHeaderMiddleware  []func(MetadataHandler) MetadataHandler
+	sendHeaderMiddleware []func(MetadataHandler) MetadataHandler
+	setTrailerMiddleware []func(MetadataHandler) MetadataHandler
 }
 
 // WrapServerStream returns a ServerStream that has the ability to overwrite context.
@@ -26,20 +36,44 @@ func wrapServerStream(stream grpc.ServerStream) *wrappedServerStream {
 	}
 }
 
-func (w *wrappedServerStream) RegisterRecvMiddleware(middleware func(StreamHandler) StreamHandler) {
-	w.recvMsgMiddleware = append(w.recvMsgMiddleware, func(handler StreamHandler) StreamHandler {
-		return outerBridge{middleware, handler}
-	})
+//WithStreamContext returns a ServerStream whose Context() is ctx, so a stream interceptor can pass values on to the handler.
+//If ss is already wrapped the wrapper is reused, so every interceptor in the chain sees the latest context.
+func WithStreamContext(ss grpc.ServerStream, ctx context.Context) grpc.ServerStream {
+	w := wrapServerStream(ss)
+	w.WrappedContext = ctx
+	return w
+}
 
-	w.recvMsgDispatch = buildChain(w.ServerStream.RecvMsg, w.recvMsgMiddleware)
+//RegisterRecvMiddleware adds middleware around RecvMsg. Middleware registered first runs first.
+//Middleware should be registered before the handler starts using the stream.
+func (w *wrappedServerStream) RegisterRecvMiddleware(middleware func(StreamHandler) StreamHandler) {
+	w.recvMsgMiddleware = append(w.recvMsgMiddleware, middleware)
+	w.recvMsgDispatch = nil
 }
 
+//RegisterSendMiddleware adds middleware around SendMsg. Middleware registered first runs first.
+//Middleware should be registered before the handler starts using the stream.
 func (w *wrappedServerStream) RegisterSendMiddleware(middleware func(StreamHandler) StreamHandler) {
-	w.sendMsgMiddleware = append(w.sendMsgMiddleware, func(handler StreamHandler) StreamHandler {
-		return outerBridge{middleware, handler}
-	})
+	w.sendMsgMiddleware = append(w.sendMsgMiddleware, middleware)
+	w.sendMsgDispatch = nil
+}
+
+//RegisterSetHeaderMiddleware adds middleware around SetHeader
+func (w *wrappedServerStream) RegisterSetHeaderMiddleware(middleware func(MetadataHandler) MetadataHandler) {
+	w.setHeaderMiddleware = append(w.setHeaderMiddleware, middleware)
+	w.setHeaderDispatch = nil
+}
+
+//RegisterSendHeaderMiddleware adds middleware around SendHeader
+func (w *wrappedServerStream) RegisterSendHeaderMiddleware(middleware func(MetadataHandler) MetadataHandler) {
+	w.sendHeaderMiddleware = append(w.sendHeaderMiddleware, middleware)
+	w.sendHeaderDispatch = nil
+}
 
-	w.sendMsgDispatch = buildChain(w.ServerStream.SendMsg, w.sendMsgMiddleware)
+//RegisterSetTrailerMiddleware adds middleware around SetTrailer. SetTrailer can't fail, so errors returned by the chain are dropped.
+func (w *wrappedServerStream) RegisterSetTrailerMiddleware(middleware func(MetadataHandler) MetadataHandler) {
+	w.setTrailerMiddleware = append(w.setTrailerMiddleware, middleware)
+	w.setTrailerDispatch = nil
 }
 
 //builds the stream middleware chain
@@ -54,6 +88,18 @@ func buildChain(root func(interface{}) error, middleware []func(StreamHandler) S
 	return handler
 }
 
+//builds the header/trailer middleware chain
+func buildMetadataChain(root func(metadata.MD) error, middleware []func(MetadataHandler) MetadataHandler) MetadataHandler {
+	var handler MetadataHandler
+	handler = MetadataFunc(root)
+
+	for i := len(middleware) - 1; i >= 0; i-- {
+		handler = middleware[i](handler)
+	}
+
+	return handler
+}
+
 // Context returns the wrapper's WrappedContext, overwriting the nested grpc.ServerStream.Context()
 func (w *wrappedServerStream) Context() context.Context {
 	return w.WrappedContext
@@ -61,14 +107,50 @@ func (w *wrappedServerStream) Context() context.Context {
 
 //SendMsg calls SendMsg on the underlying grpc.ServerStream but allows for middleware
 func (w *wrappedServerStream) SendMsg(m interface{}) error {
+	if w.sendMsgDispatch == nil {
+		w.sendMsgDispatch = buildChain(w.ServerStream.SendMsg, w.sendMsgMiddleware)
+	}
 	return w.sendMsgDispatch.Stream(m)
 }
 
 //RecvMsg calls RecvMsg on the underlying grpc.ServerStream but allows for middleware
 func (w *wrappedServerStream) RecvMsg(m interface{}) error {
+	if w.recvMsgDispatch == nil {
+		w.recvMsgDispatch = buildChain(w.ServerStream.RecvMsg, w.recvMsgMiddleware)
+	}
 	return w.recvMsgDispatch.Stream(m)
 }
 
+//SetHeader calls SetHeader on the underlying grpc.ServerStream but allows for middleware
+func (w *wrappedServerStream) SetHeader(md metadata.MD) error {
+	if w.setHeaderDispatch == nil {
+		w.setHeaderDispatch = buildMetadataChain(w.ServerStream.SetHeader, w.setHeaderMiddleware)
+	}
+	return w.setHeaderDispatch.Metadata(md)
+}
+
+//SendHeader calls SendHeader on the underlying grpc.ServerStream but allows for middleware
+func (w *wrappedServerStream) SendHeader(md metadata.MD) error {
+	if w.sendHeaderDispatch == nil {
+		w.sendHeaderDispatch = buildMetadataChain(w.ServerStream.SendHeader, w.sendHeaderMiddleware)
+	}
+	return w.sendHeaderDispatch.Metadata(md)
+}
+
+//SetTrailer calls SetTrailer on the underlying grpc.ServerStream but allows for middleware
+func (w *wrappedServerStream) SetTrailer(md metadata.MD) {
+	if w.setTrailerDispatch == nil {
+		w.setTrailerDispatch = buildMetadataChain(w.setTrailer, w.setTrailerMiddleware)
+	}
+	w.setTrailerDispatch.Metadata(md)
+}
+
+//setTrailer adapts SetTrailer on the underlying grpc.ServerStream to the MetadataFunc signature
+func (w *wrappedServerStream) setTrailer(md metadata.MD) error {
+	w.ServerStream.SetTrailer(md)
+	return nil
+}
+
 //Middleware BS
 
 //StreamFunc implements stream
@@ -83,21 +165,14 @@ type StreamHandler interface {
 	Stream(m interface{}) error
 }
 
-//outerBridge that calls an inner StreamHandler
-type outerBridge struct {
-	mware func(StreamHandler) StreamHandler
-	inner StreamHandler
-}
-
-func (b outerBridge) Stream(m interface{}) error {
-	return b.mware(innerBridge{b.inner}).Stream(m)
-}
+//MetadataFunc implements MetadataHandler
+type MetadataFunc func(md metadata.MD) error
 
-//innerBridge that executes a stream
-type innerBridge struct {
-	inner StreamHandler
+func (f MetadataFunc) Metadata(md metadata.MD) error {
+	return f(md)
 }
 
-func (b innerBridge) Stream(m interface{}) error {
-	return b.inner.Stream(m)
+//MetadataHandler handles header or trailer metadata
+type MetadataHandler interface {
+	Metadata(md metadata.MD) error
 }
@@ -0,0 +1,210 @@
+package middleware
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//fakeServerStream is a grpc.ServerStream that doesn't touch the network
+type fakeServerStream struct {
+	ctx     context.Context
+	sent    int
+	recvd   int
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (f *fakeServerStream) SetHeader(md metadata.MD) error {
+	f.header = metadata.Join(f.header, md)
+	return nil
+}
+
+func (f *fakeServerStream) SendHeader(md metadata.MD) error {
+	return f.SetHeader(md)
+}
+
+func (f *fakeServerStream) SetTrailer(md metadata.MD) {
+	f.trailer = metadata.Join(f.trailer, md)
+}
+
+func (f *fakeServerStream) Context() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+func (f *fakeServerStream) SendMsg(m interface{}) error {
+	f.sent++
+	return nil
+}
+
+func (f *fakeServerStream) RecvMsg(m interface{}) error {
+	f.recvd++
+	return nil
+}
+
+var _ grpc.ServerStream = &fakeServerStream{}
+
+//passThrough is a send/recv middleware that does nothing but call the next handler
+func passThrough(next StreamHandler) StreamHandler {
+	return StreamFunc(func(m interface{}) error {
+		return next.Stream(m)
+	})
+}
+
+func TestWrappedStreamOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) func(StreamHandler) StreamHandler {
+		return func(next StreamHandler) StreamHandler {
+			return StreamFunc(func(m interface{}) error {
+				order = append(order, name)
+				return next.Stream(m)
+			})
+		}
+	}
+
+	fake := &fakeServerStream{}
+	w := wrapServerStream(fake)
+	w.RegisterSendMiddleware(mark("first"))
+	w.RegisterSendMiddleware(mark("second"))
+
+	if err := w.SendMsg(nil); err != nil {
+		t.Fatal(err)
+	}
+	//registering after the chain was built must rebuild it
+	w.RegisterSendMiddleware(mark("third"))
+	if err := w.SendMsg(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"first", "second", "first", "second", "third"}
+	if len(order) != len(want) {
+		t.Fatalf("got %v, want %v", order, want)
+	}
+	for i := range want {
+		if order[i] != want[i] {
+			t.Fatalf("got %v, want %v", order, want)
+		}
+	}
+	if fake.sent != 2 {
+		t.Errorf("underlying SendMsg called %d times, want 2", fake.sent)
+	}
+}
+
+func TestWrappedStreamMetadata(t *testing.T) {
+	fake := &fakeServerStream{}
+	w := wrapServerStream(fake)
+	w.RegisterSetTrailerMiddleware(func(next MetadataHandler) MetadataHandler {
+		return MetadataFunc(func(md metadata.MD) error {
+			return next.Metadata(metadata.Join(md, metadata.Pairs("added", "yes")))
+		})
+	})
+
+	w.SetTrailer(metadata.Pairs("k", "v"))
+	if got := fake.trailer["added"]; len(got) != 1 || got[0] != "yes" {
+		t.Errorf("trailer = %v, want added=yes", fake.trailer)
+	}
+	if got := fake.trailer["k"]; len(got) != 1 || got[0] != "v" {
+		t.Errorf("trailer = %v, want k=v", fake.trailer)
+	}
+}
+
+func TestWithStreamContextReusesWrapper(t *testing.T) {
+	fake := &fakeServerStream{}
+	type key struct{}
+
+	first := WithStreamContext(fake, context.WithValue(context.Background(), key{}, 1))
+	second := WithStreamContext(first, context.WithValue(first.Context(), key{}, 2))
+	if first != second {
+		t.Fatal("WithStreamContext wrapped an already wrapped stream")
+	}
+	if got := second.Context().Value(key{}); got != 2 {
+		t.Errorf("context value = %v, want 2", got)
+	}
+}
+
+const benchMiddleware = 5
+
+func BenchmarkSendMsg(b *testing.B) {
+	w := wrapServerStream(&fakeServerStream{})
+	for i := 0; i < benchMiddleware; i++ {
+		w.RegisterSendMiddleware(passThrough)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.SendMsg(nil)
+	}
+}
+
+func BenchmarkRecvMsg(b *testing.B) {
+	w := wrapServerStream(&fakeServerStream{})
+	for i := 0; i < benchMiddleware; i++ {
+		w.RegisterRecvMiddleware(passThrough)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.RecvMsg(nil)
+	}
+}
+
+//bridgeChain builds a chain the way wrappedServerStream did before chains were prebuilt:
+//every middleware is wrapped in an outerBridge that calls the middleware constructor again for each message.
+func bridgeChain(root func(interface{}) error, middleware []func(StreamHandler) StreamHandler) StreamHandler {
+	bridged := make([]func(StreamHandler) StreamHandler, len(middleware))
+	for i, mware := range middleware {
+		mware := mware
+		bridged[i] = func(handler StreamHandler) StreamHandler {
+			return outerBridge{mware, handler}
+		}
+	}
+	return buildChain(root, bridged)
+}
+
+type outerBridge struct {
+	mware func(StreamHandler) StreamHandler
+	inner StreamHandler
+}
+
+func (b outerBridge) Stream(m interface{}) error {
+	return b.mware(innerBridge{b.inner}).Stream(m)
+}
+
+type innerBridge struct {
+	inner StreamHandler
+}
+
+func (b innerBridge) Stream(m interface{}) error {
+	return b.inner.Stream(m)
+}
+
+func benchMiddlewareList() []func(StreamHandler) StreamHandler {
+	middleware := make([]func(StreamHandler) StreamHandler, benchMiddleware)
+	for i := range middleware {
+		middleware[i] = passThrough
+	}
+	return middleware
+}
+
+func BenchmarkSendMsgOuterBridge(b *testing.B) {
+	chain := bridgeChain((&fakeServerStream{}).SendMsg, benchMiddlewareList())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chain.Stream(nil)
+	}
+}
+
+func BenchmarkRecvMsgOuterBridge(b *testing.B) {
+	chain := bridgeChain((&fakeServerStream{}).RecvMsg, benchMiddlewareList())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		chain.Stream(nil)
+	}
+}
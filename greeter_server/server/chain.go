@@ -0,0 +1,68 @@
+package server
+
+import (
+	"sync/atomic"
+
+	"github.com/mwitkow/go-grpc-middleware"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+)
+
+//Chain holds the interceptors built from a middleware config and lets them be swapped while the server is running.
+//Calls that already started keep running on the chain they started with.
+type Chain struct {
+	current atomic.Value
+}
+
+//chainState is swapped as a whole so a reload never mixes unary and stream interceptors from different configs
+type chainState struct {
+	unary  grpc.UnaryServerInterceptor
+	stream grpc.StreamServerInterceptor
+	//unaryLinks and streamLinks describe the config for Describe, and are replaced along with it
+	unaryLinks  []link
+	streamLinks []link
+}
+
+//NewChain applies cfg and creates a Chain running its interceptors
+func NewChain(cfg *middleware.Config) (*Chain, error) {
+	c := &Chain{}
+	if err := c.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+//Reload applies cfg, see middleware.Config.Apply, and then atomically replaces the interceptors with the ones in cfg.
+//If cfg can't be applied the chain keeps running the old interceptors.
+func (c *Chain) Reload(cfg *middleware.Config) error {
+	if err := cfg.Apply(); err != nil {
+		return err
+	}
+
+	c.current.Store(&chainState{
+		unary:       grpc_middleware.ChainUnaryServer(cfg.UnaryInterceptors()...),
+		stream:      grpc_middleware.ChainStreamServer(cfg.StreamInterceptors()...),
+		unaryLinks:  configLinks(cfg.Unary),
+		streamLinks: configLinks(cfg.Stream),
+	})
+	return nil
+}
+
+func (c *Chain) load() *chainState {
+	return c.current.Load().(*chainState)
+}
+
+//Unary returns an interceptor that runs the current unary chain
+func (c *Chain) Unary() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		return c.load().unary(ctx, req, info, handler)
+	}
+}
+
+//Stream returns an interceptor that runs the current stream chain
+func (c *Chain) Stream() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return c.load().stream(srv, ss, info, handler)
+	}
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware"
+	"google.golang.org/grpc"
+)
+
+//link is what Describe knows about one interceptor in the chain
+type link struct {
+	name   string
+	filter middleware.MethodFilter
+	//chain is set for a reloadable chain, whose links are looked up each time the server is described
+	chain *Chain
+}
+
+//MethodChain is the ordered list of interceptors that wrap a method
+type MethodChain struct {
+	FullMethod   string `json:"fullMethod"`
+	Stream       bool   `json:"stream"`
+	Interceptors []Link `json:"interceptors"`
+}
+
+//Link is one interceptor in a MethodChain
+type Link struct {
+	Name string `json:"name"`
+	//Skipped is set when a per-method rule keeps the interceptor from running for the method
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+var (
+	linksMu sync.RWMutex
+	links   = map[*grpc.Server][2][]link{}
+)
+
+func registerLinks(s *grpc.Server, unary, stream []link) {
+	linksMu.Lock()
+	defer linksMu.Unlock()
+	links[s] = [2][]link{unary, stream}
+}
+
+//Describe lists every method registered on a server created by New, with the interceptors in effect for it in the order they run
+func Describe(s *grpc.Server) []MethodChain {
+	linksMu.RLock()
+	l, ok := links[s]
+	linksMu.RUnlock()
+
+	var chains []MethodChain
+	for service, info := range s.GetServiceInfo() {
+		for _, m := range info.Methods {
+			mc := MethodChain{
+				FullMethod: "/" + service + "/" + m.Name,
+				Stream:     m.IsClientStream || m.IsServerStream,
+			}
+
+			if ok {
+				chainLinks := l[0]
+				if mc.Stream {
+					chainLinks = l[1]
+				}
+				mc.Interceptors = describeLinks(chainLinks, mc.FullMethod, mc.Stream)
+			}
+
+			chains = append(chains, mc)
+		}
+	}
+
+	sort.Slice(chains, func(i, j int) bool {
+		return chains[i].FullMethod < chains[j].FullMethod
+	})
+	return chains
+}
+
+func describeLinks(chainLinks []link, fullMethod string, stream bool) []Link {
+	var described []Link
+	for _, l := range chainLinks {
+		if l.chain != nil {
+			described = append(described, describeLinks(l.chain.links(stream), fullMethod, stream)...)
+			continue
+		}
+
+		described = append(described, Link{
+			Name:    l.name,
+			Skipped: !l.filter.Match(fullMethod),
+		})
+	}
+	return described
+}
+
+//links returns the links of the config the chain is currently running
+func (c *Chain) links(stream bool) []link {
+	state := c.load()
+	if stream {
+		return state.streamLinks
+	}
+	return state.unaryLinks
+}
+
+func configLinks(entries []middleware.InterceptorConfig) []link {
+	var l []link
+	for _, e := range entries {
+		l = append(l, link{name: e.Name, filter: e.Filter()})
+	}
+	return l
+}
+
+//funcName names an interceptor after the function that created it, e.g. middleware.UnaryLogging or middleware.UnaryUniversalDeadline
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer())
+	if fn == nil {
+		return "unknown"
+	}
+
+	name := fn.Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+
+	//Closures returned by constructors are named like middleware.UnaryAuth.func1
+	if i := strings.Index(name, ".func"); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+//DescribeHandler serves Describe(s) as text, or as JSON with ?format=json
+func DescribeHandler(s *grpc.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		chains := Describe(s)
+
+		if r.URL.Query().Get("format") == "json" {
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(chains)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, mc := range chains {
+			kind := "unary"
+			if mc.Stream {
+				kind = "stream"
+			}
+			fmt.Fprintf(w, "%s (%s)\n", mc.FullMethod, kind)
+
+			n := 0
+			for _, l := range mc.Interceptors {
+				if l.Skipped {
+					continue
+				}
+				n++
+				fmt.Fprintf(w, "  %d. %s\n", n, l.Name)
+			}
+			for _, l := range mc.Interceptors {
+				if l.Skipped {
+					fmt.Fprintf(w, "  skipped: %s\n", l.Name)
+				}
+			}
+			fmt.Fprintln(w)
+		}
+	})
+}
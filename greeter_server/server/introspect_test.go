@@ -0,0 +1,87 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware"
+	pb "github.com/troylelandshields/helloworld_grpctooling_poc/helloworld"
+	"google.golang.org/grpc"
+)
+
+//greeter is only registered so Describe has methods to list, it's never called
+type greeter struct {
+	pb.GreeterServer
+}
+
+const (
+	sayHello       = "/helloworld.Greeter/SayHello"
+	sayHelloSlow   = "/helloworld.Greeter/SayHelloSlow"
+	sayHelloToMany = "/helloworld.Greeter/SayHelloToMany"
+)
+
+func describe(s *grpc.Server) map[string][]Link {
+	described := map[string][]Link{}
+	for _, mc := range Describe(s) {
+		described[mc.FullMethod] = mc.Interceptors
+	}
+	return described
+}
+
+func TestDescribeFilteredInterceptors(t *testing.T) {
+	s := New(
+		WithoutDefaults(),
+		WithUnaryFor(middleware.OnlyMethods(sayHelloSlow), middleware.UnaryAuth()),
+		WithUnary(middleware.UnaryLogging),
+		WithStreamFor(middleware.ExceptMethods(sayHelloToMany), middleware.StreamAuth()),
+	)
+	pb.RegisterGreeterServer(s, greeter{})
+	described := describe(s)
+
+	tests := []struct {
+		method string
+		want   []Link
+	}{
+		{sayHello, []Link{{Name: "middleware.UnaryAuth", Skipped: true}, {Name: "middleware.UnaryLogging"}}},
+		{sayHelloSlow, []Link{{Name: "middleware.UnaryAuth"}, {Name: "middleware.UnaryLogging"}}},
+		{sayHelloToMany, []Link{{Name: "middleware.StreamAuth", Skipped: true}}},
+	}
+
+	for _, test := range tests {
+		if got := described[test.method]; !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.method, got, test.want)
+		}
+	}
+}
+
+func TestDescribeChainReload(t *testing.T) {
+	parse := func(config string) *middleware.Config {
+		cfg, err := middleware.ParseConfig(strings.NewReader(config))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cfg
+	}
+
+	c, err := NewChain(parse(`{"unary": [{"name": "auth"}, {"name": "metrics", "only": ["/helloworld.Greeter/SayHelloSlow"]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(WithoutDefaults(), WithChain(c))
+	pb.RegisterGreeterServer(s, greeter{})
+
+	want := []Link{{Name: "auth"}, {Name: "metrics", Skipped: true}}
+	if got := describe(s)[sayHello]; !reflect.DeepEqual(got, want) {
+		t.Errorf("before reload: got %+v, want %+v", got, want)
+	}
+
+	//only the reloaded config is listed, nothing is left over from the first one
+	if err := c.Reload(parse(`{"unary": [{"name": "tracing"}]}`)); err != nil {
+		t.Fatal(err)
+	}
+	want = []Link{{Name: "tracing"}}
+	if got := describe(s)[sayHello]; !reflect.DeepEqual(got, want) {
+		t.Errorf("after reload: got %+v, want %+v", got, want)
+	}
+}
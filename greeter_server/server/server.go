@@ -4,19 +4,218 @@ import (
 	"github.com/mwitkow/go-grpc-middleware"
 	"github.com/troylelandshields/helloworld_grpctooling_poc/greeter_server/middleware"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/stats"
 )
 
-var defaultUnaryMiddleware = []grpc.UnaryServerInterceptor{middleware.UnaryLogging, middleware.UnaryMetrics}
-var defaultStreamingMiddleware = []grpc.StreamServerInterceptor{middleware.StreamLogging}
+//defaultOptions add the default middleware. The request ID and the span have to come first so the logging middleware
+//and the request log can use them.
+var defaultOptions = []Option{
+	WithMiddleware(middleware.RequestID, middleware.DefaultInFlight.Middleware()),
+	WithUnary(middleware.UnaryTracing, middleware.UnaryLogging, middleware.UnaryMetrics),
+	WithStream(middleware.StreamTracing, middleware.StreamLogging, middleware.StreamMetrics),
+	WithMiddleware(middleware.DefaultRequestLog.Middleware()),
+}
+
+func defaults() *options {
+	o := &options{}
+	for _, opt := range defaultOptions {
+		opt(o)
+	}
+	return o
+}
+
+//DefaultUnaryMiddleware returns the interceptors New adds to unary endpoints unless WithoutDefaults is used
+func DefaultUnaryMiddleware() []grpc.UnaryServerInterceptor {
+	return defaults().unary
+}
+
+//DefaultStreamingMiddleware returns the interceptors New adds to streaming endpoints unless WithoutDefaults is used
+func DefaultStreamingMiddleware() []grpc.StreamServerInterceptor {
+	return defaults().stream
+}
+
+type options struct {
+	unary         []grpc.UnaryServerInterceptor
+	stream        []grpc.StreamServerInterceptor
+	unaryLinks    []link
+	streamLinks   []link
+	noDefaults    bool
+	defaultsFirst bool
+	grpcOpts      []grpc.ServerOption
+}
 
-func New(unaryMiddleWare []grpc.UnaryServerInterceptor, streamMiddleware []grpc.StreamServerInterceptor) *grpc.Server {
+//Option configures the server created by New
+type Option func(*options)
+
+//WithUnary adds interceptors for unary endpoints. Interceptors run in the order they are added.
+func WithUnary(interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		o.unary = append(o.unary, interceptors...)
+		for _, i := range interceptors {
+			o.unaryLinks = append(o.unaryLinks, link{name: funcName(i)})
+		}
+	}
+}
+
+//WithStream adds interceptors for streaming endpoints. Interceptors run in the order they are added.
+func WithStream(interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		o.stream = append(o.stream, interceptors...)
+		for _, i := range interceptors {
+			o.streamLinks = append(o.streamLinks, link{name: funcName(i)})
+		}
+	}
+}
+
+//WithUnaryFor adds interceptors for the unary methods matched by the filter, like WithUnary with middleware.UnaryFor.
+//Describe lists them under their own names and shows the methods they skip.
+func WithUnaryFor(f middleware.MethodFilter, interceptors ...grpc.UnaryServerInterceptor) Option {
+	return func(o *options) {
+		for _, i := range interceptors {
+			o.unary = append(o.unary, middleware.UnaryFor(f, i))
+			o.unaryLinks = append(o.unaryLinks, link{name: funcName(i), filter: f})
+		}
+	}
+}
+
+//WithStreamFor adds interceptors for the streaming methods matched by the filter, like WithStream with middleware.StreamFor.
+//Describe lists them under their own names and shows the methods they skip.
+func WithStreamFor(f middleware.MethodFilter, interceptors ...grpc.StreamServerInterceptor) Option {
+	return func(o *options) {
+		for _, i := range interceptors {
+			o.stream = append(o.stream, middleware.StreamFor(f, i))
+			o.streamLinks = append(o.streamLinks, link{name: funcName(i), filter: f})
+		}
+	}
+}
+
+//WithMiddleware adds middleware to both unary and streaming endpoints, in order with WithUnary and WithStream
+func WithMiddleware(mware ...middleware.Middleware) Option {
+	return func(o *options) {
+		for _, mw := range mware {
+			o.unary = append(o.unary, mw.Unary())
+			o.stream = append(o.stream, mw.Stream())
+
+			l := link{name: mw.Name, filter: mw.Methods}
+			if l.name == "" {
+				l.name = "middleware.Middleware"
+			}
+			o.unaryLinks = append(o.unaryLinks, l)
+			o.streamLinks = append(o.streamLinks, l)
+		}
+	}
+}
+
+//WithConfig adds the interceptors described by a middleware config file, in order with WithUnary and WithStream.
+//cfg has to be applied with Apply first if it has an access log; use WithChain to have that done and to be able to reload it.
+func WithConfig(cfg *middleware.Config) Option {
+	return func(o *options) {
+		o.unary = append(o.unary, cfg.UnaryInterceptors()...)
+		o.stream = append(o.stream, cfg.StreamInterceptors()...)
+		o.unaryLinks = append(o.unaryLinks, configLinks(cfg.Unary)...)
+		o.streamLinks = append(o.streamLinks, configLinks(cfg.Stream)...)
+	}
+}
+
+//WithChain adds a reloadable chain, in order with WithUnary and WithStream. Use Chain.Reload to swap its interceptors later.
+func WithChain(c *Chain) Option {
+	return func(o *options) {
+		o.unary = append(o.unary, c.Unary())
+		o.stream = append(o.stream, c.Stream())
+		o.unaryLinks = append(o.unaryLinks, link{chain: c})
+		o.streamLinks = append(o.streamLinks, link{chain: c})
+	}
+}
+
+//WithoutDefaults leaves out the default request ID, in-flight, tracing, logging, metrics and request log middleware.
+//Use DefaultUnaryMiddleware and DefaultStreamingMiddleware to put them back somewhere else in the chain.
+func WithoutDefaults() Option {
+	return func(o *options) {
+		o.noDefaults = true
+	}
+}
+
+//WithDefaultsFirst runs the default middleware before the added interceptors instead of after them
+func WithDefaultsFirst() Option {
+	return func(o *options) {
+		o.defaultsFirst = true
+	}
+}
+
+//WithGRPCOptions passes options straight through to grpc.NewServer.
+//Interceptors should be added with WithUnary/WithStream since grpc only allows one of each.
+func WithGRPCOptions(opts ...grpc.ServerOption) Option {
+	return func(o *options) {
+		o.grpcOpts = append(o.grpcOpts, opts...)
+	}
+}
+
+//WithMaxRecvMsgSize limits the size of messages the server will receive
+func WithMaxRecvMsgSize(bytes int) Option {
+	return WithGRPCOptions(grpc.MaxRecvMsgSize(bytes))
+}
+
+//WithMaxSendMsgSize limits the size of messages the server will send
+func WithMaxSendMsgSize(bytes int) Option {
+	return WithGRPCOptions(grpc.MaxSendMsgSize(bytes))
+}
+
+//WithKeepalive sets the keepalive parameters and enforcement policy for the server's connections
+func WithKeepalive(params keepalive.ServerParameters, policy keepalive.EnforcementPolicy) Option {
+	return WithGRPCOptions(grpc.KeepaliveParams(params), grpc.KeepaliveEnforcementPolicy(policy))
+}
+
+//WithCreds sets the transport credentials, e.g. TLS, for the server
+func WithCreds(creds credentials.TransportCredentials) Option {
+	return WithGRPCOptions(grpc.Creds(creds))
+}
+
+//WithStatsHandler sets a handler that sees every RPC's stats, such as metrics.StatsHandler for wire sizes
+func WithStatsHandler(h stats.Handler) Option {
+	return WithGRPCOptions(grpc.StatsHandler(h))
+}
+
+//New creates a gRPC server. Unless WithoutDefaults is used the default middleware runs after the interceptors that were added.
+func New(opts ...Option) *grpc.Server {
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	unaryMiddleWare := o.unary
+	streamMiddleware := o.stream
+	unaryLinks := o.unaryLinks
+	streamLinks := o.streamLinks
 
 	//Add list of passed in middlewares to defaults
-	unaryMiddleWare = append(unaryMiddleWare, defaultUnaryMiddleware...)
-	streamMiddleware = append(streamMiddleware, defaultStreamingMiddleware...)
+	if !o.noDefaults {
+		defaults := defaults()
+
+		if o.defaultsFirst {
+			unaryMiddleWare = append(defaults.unary, unaryMiddleWare...)
+			streamMiddleware = append(defaults.stream, streamMiddleware...)
+			unaryLinks = append(defaults.unaryLinks, unaryLinks...)
+			streamLinks = append(defaults.streamLinks, streamLinks...)
+		} else {
+			unaryMiddleWare = append(unaryMiddleWare, defaults.unary...)
+			streamMiddleware = append(streamMiddleware, defaults.stream...)
+			unaryLinks = append(unaryLinks, defaults.unaryLinks...)
+			streamLinks = append(streamLinks, defaults.streamLinks...)
+		}
+	}
 
 	//grpc_middleware has to be used because grpc.Server actually only allows one interceptor
-	s := grpc.NewServer(grpc_middleware.WithUnaryServerChain(unaryMiddleWare...), grpc_middleware.WithStreamServerChain(streamMiddleware...))
+	grpcOpts := append([]grpc.ServerOption{
+		grpc_middleware.WithUnaryServerChain(unaryMiddleWare...),
+		grpc_middleware.WithStreamServerChain(streamMiddleware...),
+	}, o.grpcOpts...)
+
+	s := grpc.NewServer(grpcOpts...)
+
+	//Remember the chain so it can be listed by Describe
+	registerLinks(s, unaryLinks, streamLinks)
 
 	return s
 }
@@ -0,0 +1,382 @@
+//Package metrics is a small registry of counters, gauges and histograms that can be served in the Prometheus text format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//DefBuckets are the default histogram buckets, in seconds. They're the same as Prometheus' defaults.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+//SizeBuckets are histogram buckets for message sizes in bytes, from 64B to 4MB
+var SizeBuckets = ExponentialBuckets(64, 4, 9)
+
+//ExponentialBuckets returns count buckets, the first with an upper bound of start and each one factor times bigger than the last
+func ExponentialBuckets(start, factor float64, count int) []float64 {
+	buckets := make([]float64, count)
+	for i := range buckets {
+		buckets[i] = start
+		start *= factor
+	}
+	return buckets
+}
+
+//Default is the registry the middleware records to unless it's given another one
+var Default = NewRegistry()
+
+//Registry holds metrics by name
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+//NewRegistry creates an empty Registry
+func NewRegistry() *Registry {
+	return &Registry{families: map[string]*family{}}
+}
+
+type kind string
+
+const (
+	counterKind   kind = "counter"
+	gaugeKind     kind = "gauge"
+	histogramKind kind = "histogram"
+)
+
+//family is every series of one metric
+type family struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+//series is one combination of label values
+type series struct {
+	labelValues []string
+	value       float64
+
+	//Histograms only
+	bucketCounts []uint64
+	count        uint64
+}
+
+//register returns the family called name, creating it if needed. Asking for the same name with a different kind or labels is a bug.
+func (r *Registry) register(name, help string, k kind, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if f, ok := r.families[name]; ok {
+		if f.kind != k || strings.Join(f.labels, ",") != strings.Join(labels, ",") {
+			panic(fmt.Sprintf("metrics: %s already registered as a %s with labels %v", name, f.kind, f.labels))
+		}
+		return f
+	}
+
+	f := &family{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  labels,
+		buckets: buckets,
+		series:  map[string]*series{},
+	}
+	r.families[name] = f
+	return f
+}
+
+//get returns the series for the label values, creating it if needed. The family's lock has to be held.
+func (f *family) get(labelValues []string) *series {
+	if len(labelValues) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", f.name, len(f.labels), len(labelValues)))
+	}
+
+	key := strings.Join(labelValues, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == histogramKind {
+			s.bucketCounts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+//CounterVec is a counter split up by labels
+type CounterVec struct {
+	f *family
+}
+
+//Counter registers a counter, or returns the one already registered under name
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, counterKind, nil, labels)}
+}
+
+//Inc adds 1 to the counter with the label values
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+//Add adds v, which can't be negative, to the counter with the label values
+func (c *CounterVec) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic("metrics: counters can't go down")
+	}
+
+	c.f.mu.Lock()
+	c.f.get(labelValues).value += v
+	c.f.mu.Unlock()
+}
+
+//GaugeVec is a gauge split up by labels
+type GaugeVec struct {
+	f *family
+}
+
+//Gauge registers a gauge, or returns the one already registered under name
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, gaugeKind, nil, labels)}
+}
+
+//Set sets the gauge with the label values to v
+func (g *GaugeVec) Set(v float64, labelValues ...string) {
+	g.f.mu.Lock()
+	g.f.get(labelValues).value = v
+	g.f.mu.Unlock()
+}
+
+//Add adds v to the gauge with the label values and returns the new value
+func (g *GaugeVec) Add(v float64, labelValues ...string) float64 {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+
+	s := g.f.get(labelValues)
+	s.value += v
+	return s.value
+}
+
+//Inc adds 1 to the gauge with the label values and returns the new value
+func (g *GaugeVec) Inc(labelValues ...string) float64 {
+	return g.Add(1, labelValues...)
+}
+
+//Dec subtracts 1 from the gauge with the label values and returns the new value
+func (g *GaugeVec) Dec(labelValues ...string) float64 {
+	return g.Add(-1, labelValues...)
+}
+
+//Value returns the current value of the gauge with the label values
+func (g *GaugeVec) Value(labelValues ...string) float64 {
+	g.f.mu.Lock()
+	defer g.f.mu.Unlock()
+	return g.f.get(labelValues).value
+}
+
+//HistogramVec is a histogram split up by labels
+type HistogramVec struct {
+	f *family
+}
+
+//Histogram registers a histogram with the upper bounds of its buckets in increasing order, or returns the one already registered under name
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return &HistogramVec{r.register(name, help, histogramKind, buckets, labels)}
+}
+
+//Observe adds v to the histogram with the label values
+func (h *HistogramVec) Observe(v float64, labelValues ...string) {
+	h.f.mu.Lock()
+	defer h.f.mu.Unlock()
+
+	s := h.f.get(labelValues)
+	s.value += v
+	s.count++
+
+	//Bucket counts are kept per bucket and added up when written
+	i := sort.SearchFloat64s(h.f.buckets, v)
+	if i < len(s.bucketCounts) {
+		s.bucketCounts[i]++
+	}
+}
+
+//WriteText writes every metric in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.writeText(bw)
+	}
+	return bw.Flush()
+}
+
+func (f *family) writeText(w *bufio.Writer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		s := f.series[key]
+		if f.kind != histogramKind {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, labelText(f.labels, s.labelValues, "", ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += s.bucketCounts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(f.labels, s.labelValues, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, labelText(f.labels, s.labelValues, "le", "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, labelText(f.labels, s.labelValues, "", ""), formatFloat(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, labelText(f.labels, s.labelValues, "", ""), s.count)
+	}
+}
+
+//labelText renders {name="value",...}, with an extra label if extraName is set
+func labelText(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+
+	parts := make([]string, 0, len(names)+1)
+	for i, name := range names {
+		parts = append(parts, name+`="`+escapeLabel(values[i])+`"`)
+	}
+	if extraName != "" {
+		parts = append(parts, extraName+`="`+extraValue+`"`)
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+//Family is a snapshot of one metric and all of its series, for exporters that push metrics instead of being scraped
+type Family struct {
+	Name string
+	Help string
+	//Kind is "counter", "gauge" or "histogram"
+	Kind    string
+	Labels  []string
+	Buckets []float64
+	Series  []Series
+}
+
+//Series is a snapshot of one combination of label values
+type Series struct {
+	LabelValues []string
+	//Value is the counter or gauge value, or the sum of a histogram's observations
+	Value float64
+
+	//BucketCounts are the histogram observations per bucket, not cumulative. Observations bigger than the last bucket are
+	//Count minus their total.
+	BucketCounts []uint64
+	Count        uint64
+}
+
+//Snapshot copies every metric, sorted by name and then label values
+func (r *Registry) Snapshot() []Family {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].name < families[j].name
+	})
+
+	snapshot := make([]Family, 0, len(families))
+	for _, f := range families {
+		snapshot = append(snapshot, f.snapshot())
+	}
+	return snapshot
+}
+
+func (f *family) snapshot() Family {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.series))
+	for key := range f.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	fam := Family{
+		Name:    f.name,
+		Help:    f.help,
+		Kind:    string(f.kind),
+		Labels:  f.labels,
+		Buckets: f.buckets,
+	}
+	for _, key := range keys {
+		s := f.series[key]
+		fam.Series = append(fam.Series, Series{
+			LabelValues:  s.labelValues,
+			Value:        s.value,
+			BucketCounts: append([]uint64(nil), s.bucketCounts...),
+			Count:        s.count,
+		})
+	}
+	return fam
+}
+
+//Handler serves the registry in the Prometheus text format, for /metrics
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		r.WriteText(w)
+	})
+}
@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http/httptest"
+	"testing"
+)
+
+func writeText(t *testing.T, r *Registry) string {
+	var buf bytes.Buffer
+	if err := r.WriteText(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.String()
+}
+
+func TestWriteTextCounterAndGauge(t *testing.T) {
+	r := NewRegistry()
+	c := r.Counter("requests_total", "Requests handled.", "method", "code")
+	c.Inc("/b", "OK")
+	c.Add(2, "/a", "OK")
+	c.Inc("/a", "NotFound")
+	g := r.Gauge("in_flight", "Calls in flight.")
+	g.Inc()
+	g.Add(0.5)
+
+	//families are sorted by name and series by label values
+	want := `# HELP in_flight Calls in flight.
+# TYPE in_flight gauge
+in_flight 1.5
+# HELP requests_total Requests handled.
+# TYPE requests_total counter
+requests_total{method="/a",code="NotFound"} 1
+requests_total{method="/a",code="OK"} 2
+requests_total{method="/b",code="OK"} 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTextHistogram(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("latency_seconds", "Call latency.", []float64{0.1, 1, 10}, "method")
+	//an observation equal to a bucket's upper bound is in that bucket, one past the last bucket only counts towards +Inf
+	for _, v := range []float64{0.05, 0.1, 0.5, 2, 20} {
+		h.Observe(v, "/a")
+	}
+	h.Observe(1, "/b")
+
+	want := `# HELP latency_seconds Call latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{method="/a",le="0.1"} 2
+latency_seconds_bucket{method="/a",le="1"} 3
+latency_seconds_bucket{method="/a",le="10"} 4
+latency_seconds_bucket{method="/a",le="+Inf"} 5
+latency_seconds_sum{method="/a"} 22.65
+latency_seconds_count{method="/a"} 5
+latency_seconds_bucket{method="/b",le="0.1"} 0
+latency_seconds_bucket{method="/b",le="1"} 1
+latency_seconds_bucket{method="/b",le="10"} 1
+latency_seconds_bucket{method="/b",le="+Inf"} 1
+latency_seconds_sum{method="/b"} 1
+latency_seconds_count{method="/b"} 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTextHistogramWithoutLabels(t *testing.T) {
+	r := NewRegistry()
+	h := r.Histogram("size_bytes", "Message sizes.", ExponentialBuckets(64, 4, 2))
+	h.Observe(100)
+
+	want := `# HELP size_bytes Message sizes.
+# TYPE size_bytes histogram
+size_bytes_bucket{le="64"} 0
+size_bytes_bucket{le="256"} 1
+size_bytes_bucket{le="+Inf"} 1
+size_bytes_sum 100
+size_bytes_count 1
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTextEscaping(t *testing.T) {
+	r := NewRegistry()
+	//help text escapes backslashes and newlines, label values also escape quotes
+	c := r.Counter("errors_total", "Errors by message.\nA \\ and a \" in help.", "message")
+	c.Inc(`say "hi"`)
+	c.Inc(`C:\path`)
+	c.Inc("two\nlines")
+	r.Gauge("temperature", "Infinite values.", "side").Set(math.Inf(1), "hot")
+	r.Gauge("temperature", "Infinite values.", "side").Set(math.Inf(-1), "cold")
+
+	want := `# HELP errors_total Errors by message.\nA \\ and a " in help.
+# TYPE errors_total counter
+errors_total{message="C:\\path"} 1
+errors_total{message="say \"hi\""} 1
+errors_total{message="two\nlines"} 1
+# HELP temperature Infinite values.
+# TYPE temperature gauge
+temperature{side="cold"} -Inf
+temperature{side="hot"} +Inf
+`
+	if got := writeText(t, r); got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("up", "Always one.").Inc()
+
+	w := httptest.NewRecorder()
+	r.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); ct != "text/plain; version=0.0.4; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if got, want := w.Body.String(), "# HELP up Always one.\n# TYPE up counter\nup 1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestRegisterMismatch(t *testing.T) {
+	r := NewRegistry()
+	r.Counter("calls_total", "Calls.", "method")
+	//the same name and labels give back the same metric
+	r.Counter("calls_total", "Calls.", "method").Inc("/a")
+	if v := value(r, "calls_total", "/a"); v != 1 {
+		t.Errorf("calls_total = %v, want 1", v)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering calls_total as a gauge didn't panic")
+		}
+	}()
+	r.Gauge("calls_total", "Calls.", "method")
+}
@@ -0,0 +1,17 @@
+package metrics
+
+import "time"
+
+//Sink is somewhere measurements are pushed to as they happen, as opposed to a Registry which is scraped
+type Sink interface {
+	//Count adds n to the counter called name
+	Count(name string, n int64, tags ...Tag)
+	//Timing records how long something took
+	Timing(name string, d time.Duration, tags ...Tag)
+}
+
+//Tag is a name/value pair attached to a measurement, like a label on a registry metric
+type Tag struct {
+	Key   string
+	Value string
+}
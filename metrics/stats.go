@@ -0,0 +1,90 @@
+package metrics
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/stats"
+)
+
+//StatsHandler is a grpc stats.Handler that records what interceptors can't see: the size of messages before and after
+//encoding and compression, header and trailer sizes, and when each RPC begins and ends.
+//Register it with grpc.StatsHandler on a server or grpc.WithStatsHandler on a client.
+type StatsHandler struct {
+	payloadBytes *CounterVec
+	wireBytes    *CounterVec
+	messageSize  *HistogramVec
+	headerBytes  *CounterVec
+	begun        *CounterVec
+	ended        *CounterVec
+}
+
+//NewServerStatsHandler creates a StatsHandler for a server, with metrics named grpc_server_*
+func NewServerStatsHandler(r *Registry) *StatsHandler {
+	return newStatsHandler(r, "server")
+}
+
+//NewClientStatsHandler creates a StatsHandler for a client, with metrics named grpc_client_*
+func NewClientStatsHandler(r *Registry) *StatsHandler {
+	return newStatsHandler(r, "client")
+}
+
+func newStatsHandler(r *Registry, side string) *StatsHandler {
+	prefix := "grpc_" + side + "_"
+
+	return &StatsHandler{
+		payloadBytes: r.Counter(prefix+"payload_bytes_total", "Bytes of messages before encoding and compression, by method and direction. Messages whose wire size grpc doesn't report aren't counted.", "method", "direction"),
+		wireBytes:    r.Counter(prefix+"wire_bytes_total", "Bytes of messages on the wire, by method and direction. Divide payload_bytes_total by this for the compression ratio.", "method", "direction"),
+		messageSize:  r.Histogram(prefix+"wire_msg_size_bytes", "Size of each message on the wire, by method and direction.", SizeBuckets, "method", "direction"),
+		headerBytes:  r.Counter(prefix+"header_bytes_total", "Bytes of headers and trailers on the wire, by method, direction and kind. The size of sent headers isn't reported by grpc.", "method", "direction", "kind"),
+		begun:        r.Counter(prefix+"rpc_begin_total", "Number of RPCs begun, by method.", "method"),
+		ended:        r.Counter(prefix+"rpc_end_total", "Number of RPCs ended, by method and status code.", "method", "code"),
+	}
+}
+
+type statsMethodKey struct{}
+
+//TagRPC remembers the method so HandleRPC can label its metrics with it
+func (h *StatsHandler) TagRPC(ctx context.Context, info *stats.RPCTagInfo) context.Context {
+	return context.WithValue(ctx, statsMethodKey{}, info.FullMethodName)
+}
+
+//HandleRPC records the stats for an RPC
+func (h *StatsHandler) HandleRPC(ctx context.Context, s stats.RPCStats) {
+	method, _ := ctx.Value(statsMethodKey{}).(string)
+
+	switch s := s.(type) {
+	case *stats.Begin:
+		h.begun.Inc(method)
+	case *stats.InPayload:
+		h.payload(method, "received", s.Length, s.WireLength)
+	case *stats.OutPayload:
+		h.payload(method, "sent", s.Length, s.WireLength)
+	case *stats.InHeader:
+		h.headerBytes.Add(float64(s.WireLength), method, "received", "header")
+	case *stats.InTrailer:
+		h.headerBytes.Add(float64(s.WireLength), method, "received", "trailer")
+	case *stats.OutTrailer:
+		h.headerBytes.Add(float64(s.WireLength), method, "sent", "trailer")
+	case *stats.End:
+		h.ended.Inc(method, grpc.Code(s.Error).String())
+	}
+}
+
+func (h *StatsHandler) payload(method, direction string, length, wireLength int) {
+	//Older versions of grpc don't fill in the wire length of received messages. Those are left out of the payload bytes too,
+	//instead of counting their wire length as 0, so the two counters always cover the same messages.
+	if wireLength == 0 && length > 0 {
+		return
+	}
+	h.payloadBytes.Add(float64(length), method, direction)
+	h.wireBytes.Add(float64(wireLength), method, direction)
+	h.messageSize.Observe(float64(wireLength), method, direction)
+}
+
+//TagConn doesn't add anything, connections aren't tracked
+func (h *StatsHandler) TagConn(ctx context.Context, info *stats.ConnTagInfo) context.Context {
+	return ctx
+}
+
+//HandleConn doesn't do anything, connections aren't tracked
+func (h *StatsHandler) HandleConn(ctx context.Context, s stats.ConnStats) {}
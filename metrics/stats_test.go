@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/stats"
+)
+
+//value returns the value of the series of the metric called name with labelValues, or -1 if there isn't one
+func value(r *Registry, name string, labelValues ...string) float64 {
+	for _, f := range r.Snapshot() {
+		if f.Name != name {
+			continue
+		}
+		for _, s := range f.Series {
+			if equal(s.LabelValues, labelValues) {
+				if f.Kind == "histogram" {
+					return float64(s.Count)
+				}
+				return s.Value
+			}
+		}
+	}
+	return -1
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestStatsHandlerPayloads(t *testing.T) {
+	const method = "/helloworld.Greeter/SayHello"
+
+	r := NewRegistry()
+	h := NewServerStatsHandler(r)
+	ctx := h.TagRPC(context.Background(), &stats.RPCTagInfo{FullMethodName: method})
+
+	h.HandleRPC(ctx, &stats.Begin{})
+	h.HandleRPC(ctx, &stats.InPayload{Length: 100, WireLength: 60})
+	//no wire length: left out of every payload metric
+	h.HandleRPC(ctx, &stats.InPayload{Length: 100})
+	//an empty message really is 0 bytes on the wire
+	h.HandleRPC(ctx, &stats.InPayload{})
+	h.HandleRPC(ctx, &stats.OutPayload{Length: 40, WireLength: 45})
+	h.HandleRPC(ctx, &stats.InHeader{WireLength: 12})
+	h.HandleRPC(ctx, &stats.OutTrailer{WireLength: 7})
+	h.HandleRPC(ctx, &stats.End{Error: grpc.Errorf(codes.NotFound, "no")})
+
+	tests := []struct {
+		name        string
+		labelValues []string
+		want        float64
+	}{
+		{"grpc_server_payload_bytes_total", []string{method, "received"}, 100},
+		{"grpc_server_wire_bytes_total", []string{method, "received"}, 60},
+		{"grpc_server_wire_msg_size_bytes", []string{method, "received"}, 2},
+		{"grpc_server_payload_bytes_total", []string{method, "sent"}, 40},
+		{"grpc_server_wire_bytes_total", []string{method, "sent"}, 45},
+		{"grpc_server_wire_msg_size_bytes", []string{method, "sent"}, 1},
+		{"grpc_server_header_bytes_total", []string{method, "received", "header"}, 12},
+		{"grpc_server_header_bytes_total", []string{method, "sent", "trailer"}, 7},
+		{"grpc_server_rpc_begin_total", []string{method}, 1},
+		{"grpc_server_rpc_end_total", []string{method, "NotFound"}, 1},
+	}
+
+	for _, test := range tests {
+		if got := value(r, test.name, test.labelValues...); got != test.want {
+			t.Errorf("%s%v = %v, want %v", test.name, test.labelValues, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package metrics
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//StatsD is a Sink that sends measurements to a StatsD or DogStatsD agent over UDP.
+//Lines are batched into packets that are sent when they're full and every flush interval, so recording never waits on the network.
+type StatsD struct {
+	conn          net.Conn
+	prefix        string
+	tags          []Tag
+	dogStatsD     bool
+	maxPacketSize int
+	flushInterval time.Duration
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+//StatsDOption configures a StatsD sink
+type StatsDOption func(*StatsD)
+
+//WithPrefix is put in front of every metric name, e.g. "greeter." for greeter.grpc.server.handled
+func WithPrefix(prefix string) StatsDOption {
+	return func(s *StatsD) {
+		s.prefix = prefix
+	}
+}
+
+//WithTags are added to every measurement
+func WithTags(tags ...Tag) StatsDOption {
+	return func(s *StatsD) {
+		s.tags = append(s.tags, tags...)
+	}
+}
+
+//WithDogStatsD sends tags in the DogStatsD format (name:1|c|#key:value). Plain StatsD doesn't support tags, so without it
+//tag values are added to the metric name instead (name.value1.value2:1|c).
+func WithDogStatsD() StatsDOption {
+	return func(s *StatsD) {
+		s.dogStatsD = true
+	}
+}
+
+//WithFlushInterval sets how often a partly full packet is sent. The default is 1s, which is also kept if d isn't positive.
+func WithFlushInterval(d time.Duration) StatsDOption {
+	return func(s *StatsD) {
+		if d > 0 {
+			s.flushInterval = d
+		}
+	}
+}
+
+//WithMaxPacketSize sets the largest packet that will be sent. The default, 1432 bytes, fits in an ethernet frame.
+func WithMaxPacketSize(bytes int) StatsDOption {
+	return func(s *StatsD) {
+		s.maxPacketSize = bytes
+	}
+}
+
+//NewStatsD creates a StatsD sink that sends to the agent at addr, e.g. "127.0.0.1:8125"
+func NewStatsD(addr string, opts ...StatsDOption) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &StatsD{
+		conn:          conn,
+		maxPacketSize: 1432,
+		flushInterval: time.Second,
+		done:          make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.wg.Add(1)
+	go s.flushLoop()
+
+	return s, nil
+}
+
+//Count sends n as a StatsD counter
+func (s *StatsD) Count(name string, n int64, tags ...Tag) {
+	s.add(name, strconv.FormatInt(n, 10), "c", tags)
+}
+
+//Timing sends d as a StatsD timer in milliseconds
+func (s *StatsD) Timing(name string, d time.Duration, tags ...Tag) {
+	s.add(name, strconv.FormatFloat(d.Seconds()*1000, 'f', -1, 64), "ms", tags)
+}
+
+//add formats a line and adds it to the batch, sending the batch first if the line wouldn't fit
+func (s *StatsD) add(name, value, kind string, tags []Tag) {
+	line := s.line(name, value, kind, tags)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.buf.Len() > 0 && s.buf.Len()+1+len(line) > s.maxPacketSize {
+		s.flushLocked()
+	}
+	if s.buf.Len() > 0 {
+		s.buf.WriteByte('\n')
+	}
+	s.buf.WriteString(line)
+}
+
+func (s *StatsD) line(name, value, kind string, tags []Tag) string {
+	var b strings.Builder
+	b.WriteString(sanitize(s.prefix + name))
+
+	all := append(append([]Tag(nil), s.tags...), tags...)
+
+	if !s.dogStatsD {
+		for _, t := range all {
+			b.WriteByte('.')
+			b.WriteString(strings.Trim(statsdNameReplacer.Replace(t.Value), "_"))
+		}
+	}
+
+	b.WriteByte(':')
+	b.WriteString(value)
+	b.WriteByte('|')
+	b.WriteString(kind)
+
+	if s.dogStatsD && len(all) > 0 {
+		b.WriteString("|#")
+		for i, t := range all {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(dogStatsDTagReplacer.Replace(t.Key))
+			b.WriteByte(':')
+			b.WriteString(dogStatsDTagReplacer.Replace(t.Value))
+		}
+	}
+
+	return b.String()
+}
+
+var (
+	//Plain StatsD uses dots to separate the parts of a name, so they're replaced in tag values along with anything else odd
+	statsdNameReplacer   = strings.NewReplacer("/", "_", ".", "_", ":", "_", "|", "_", "@", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+	dogStatsDTagReplacer = strings.NewReplacer("|", "_", "#", "_", ",", "_", " ", "_", "\n", "_")
+)
+
+//sanitize makes name safe to use as a metric name
+func sanitize(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+//Flush sends whatever is batched right away
+func (s *StatsD) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flushLocked()
+}
+
+func (s *StatsD) flushLocked() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+
+	//UDP writes don't block on the agent; if it isn't there the packet is dropped
+	_, err := s.conn.Write(s.buf.Bytes())
+	s.buf.Reset()
+	return err
+}
+
+func (s *StatsD) flushLoop() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.Flush()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+//Close sends what's batched and closes the connection. Only the first call does anything.
+func (s *StatsD) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.wg.Wait()
+
+		err = s.Flush()
+		if cerr := s.conn.Close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
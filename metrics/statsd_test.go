@@ -0,0 +1,186 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//listen starts a fake StatsD agent
+func listen(t *testing.T) net.PacketConn {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+//readPacket returns the next packet the agent got, or fails the test if none comes
+func readPacket(t *testing.T, conn net.PacketConn) string {
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("no packet: %v", err)
+	}
+	return string(buf[:n])
+}
+
+//expectNoPacket fails the test if the agent gets a packet within wait
+func expectNoPacket(t *testing.T, conn net.PacketConn, wait time.Duration) {
+	buf := make([]byte, 65536)
+	conn.SetReadDeadline(time.Now().Add(wait))
+	if n, _, err := conn.ReadFrom(buf); err == nil {
+		t.Fatalf("unexpected packet %q", buf[:n])
+	}
+}
+
+func TestStatsDFormat(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []StatsDOption
+		want string
+	}{
+		{
+			name: "plain",
+			opts: []StatsDOption{WithPrefix("greeter."), WithTags(Tag{"env", "prod"})},
+			want: "greeter.grpc.server.handled.prod.helloworld_Greeter_SayHello.OK:1|c\n" +
+				"greeter.grpc.server.latency.prod:1.5|ms",
+		},
+		{
+			name: "dogstatsd",
+			opts: []StatsDOption{WithPrefix("greeter."), WithTags(Tag{"env", "prod"}), WithDogStatsD()},
+			want: "greeter.grpc.server.handled:1|c|#env:prod,method:/helloworld.Greeter/SayHello,code:OK\n" +
+				"greeter.grpc.server.latency:1.5|ms|#env:prod",
+		},
+		{
+			name: "no prefix or tags",
+			opts: nil,
+			want: "grpc.server.handled.helloworld_Greeter_SayHello.OK:1|c\n" +
+				"grpc.server.latency:1.5|ms",
+		},
+		{
+			name: "sanitized",
+			opts: []StatsDOption{WithPrefix("my app:"), WithTags(Tag{"team name", "a|b,c"}), WithDogStatsD()},
+			want: "my_app_grpc.server.handled:1|c|#team_name:a_b_c,method:/helloworld.Greeter/SayHello,code:OK\n" +
+				"my_app_grpc.server.latency:1.5|ms|#team_name:a_b_c",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			agent := listen(t)
+			defer agent.Close()
+
+			s, err := NewStatsD(agent.LocalAddr().String(), append(test.opts, WithFlushInterval(time.Hour))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+
+			s.Count("grpc.server.handled", 1, Tag{"method", "/helloworld.Greeter/SayHello"}, Tag{"code", "OK"})
+			s.Timing("grpc.server.latency", 1500*time.Microsecond)
+			if err := s.Flush(); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := readPacket(t, agent); got != test.want {
+				t.Errorf("got\n%s\nwant\n%s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestStatsDBatchSplitting(t *testing.T) {
+	agent := listen(t)
+	defer agent.Close()
+
+	//each line is "n:1|c", 5 bytes, so 5 lines and their newlines fit in 29 bytes
+	const maxPacketSize = 30
+	s, err := NewStatsD(agent.LocalAddr().String(), WithMaxPacketSize(maxPacketSize), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for i := 0; i < 12; i++ {
+		s.Count("n", 1)
+	}
+
+	//the first two packets are sent as soon as the next line doesn't fit
+	for i := 0; i < 2; i++ {
+		got := readPacket(t, agent)
+		if len(got) > maxPacketSize {
+			t.Errorf("packet %d is %d bytes, want at most %d", i, len(got), maxPacketSize)
+		}
+		if lines := strings.Split(got, "\n"); len(lines) != 5 {
+			t.Errorf("packet %d has %d lines, want 5: %q", i, len(lines), got)
+		}
+	}
+	expectNoPacket(t, agent, 50*time.Millisecond)
+
+	//the rest waits for a flush
+	s.Flush()
+	if got := readPacket(t, agent); got != "n:1|c\nn:1|c" {
+		t.Errorf("last packet = %q, want 2 lines", got)
+	}
+}
+
+func TestStatsDFlushInterval(t *testing.T) {
+	agent := listen(t)
+	defer agent.Close()
+
+	s, err := NewStatsD(agent.LocalAddr().String(), WithFlushInterval(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	s.Count("ticked", 2)
+	if got := readPacket(t, agent); got != "ticked:2|c" {
+		t.Errorf("got %q, want ticked:2|c", got)
+	}
+}
+
+func TestStatsDFlushIntervalDefault(t *testing.T) {
+	agent := listen(t)
+	defer agent.Close()
+
+	//time.NewTicker panics on an interval that isn't positive
+	for _, d := range []time.Duration{0, -time.Second} {
+		s, err := NewStatsD(agent.LocalAddr().String(), WithFlushInterval(d))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if s.flushInterval != time.Second {
+			t.Errorf("WithFlushInterval(%s): interval = %s, want the default 1s", d, s.flushInterval)
+		}
+		s.Close()
+	}
+}
+
+func TestStatsDClose(t *testing.T) {
+	agent := listen(t)
+	defer agent.Close()
+
+	s, err := NewStatsD(agent.LocalAddr().String(), WithFlushInterval(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.Count("buffered", 1)
+	expectNoPacket(t, agent, 20*time.Millisecond)
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := readPacket(t, agent); got != "buffered:1|c" {
+		t.Errorf("got %q, want buffered:1|c", got)
+	}
+
+	//closing again is a no-op
+	if err := s.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+}
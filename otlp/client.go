@@ -0,0 +1,151 @@
+package otlp
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+//signal is what kind of telemetry is being sent
+type signal int
+
+const (
+	signalTraces signal = iota
+	signalMetrics
+)
+
+//client sends an encoded export request to the collector
+type client interface {
+	send(ctx context.Context, sig signal, body []byte) error
+	close() error
+}
+
+//retryableError is a failure that might work if it's tried again, e.g. the collector is busy or unreachable
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string {
+	return e.err.Error()
+}
+
+func isRetryable(err error) bool {
+	_, ok := err.(retryableError)
+	return ok
+}
+
+//grpcClient sends to the collector's TraceService and MetricsService
+type grpcClient struct {
+	cc      *grpc.ClientConn
+	headers metadata.MD
+}
+
+var grpcMethods = map[signal]string{
+	signalTraces:  "/opentelemetry.proto.collector.trace.v1.TraceService/Export",
+	signalMetrics: "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export",
+}
+
+func (c *grpcClient) send(ctx context.Context, sig signal, body []byte) error {
+	if len(c.headers) > 0 {
+		ctx = metadata.NewContext(ctx, c.headers)
+	}
+
+	//The response only matters for partial success, which isn't acted on
+	var resp []byte
+	err := grpc.Invoke(ctx, grpcMethods[sig], &body, &resp, c.cc)
+	if err == nil {
+		return nil
+	}
+
+	//The codes the OTLP spec says can be retried
+	switch grpc.Code(err) {
+	case codes.Canceled, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.OutOfRange, codes.Unavailable, codes.DataLoss:
+		return retryableError{err}
+	}
+	return err
+}
+
+func (c *grpcClient) close() error {
+	return c.cc.Close()
+}
+
+//rawCodec passes already encoded protobuf messages through untouched
+type rawCodec struct{}
+
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("otlp: can't marshal %T", v)
+	}
+	return *b, nil
+}
+
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("otlp: can't unmarshal into %T", v)
+	}
+	*b = append((*b)[:0], data...)
+	return nil
+}
+
+func (rawCodec) String() string {
+	return "proto"
+}
+
+//httpClient POSTs to the collector's /v1/traces and /v1/metrics
+type httpClient struct {
+	client   *http.Client
+	endpoint string
+	headers  map[string]string
+}
+
+var httpPaths = map[signal]string{
+	signalTraces:  "/v1/traces",
+	signalMetrics: "/v1/metrics",
+}
+
+func (c *httpClient) send(ctx context.Context, sig signal, body []byte) error {
+	req, err := http.NewRequest("POST", strings.TrimSuffix(c.endpoint, "/")+httpPaths[sig], bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	for k, v := range c.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		//Couldn't reach the collector
+		return retryableError{err}
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	err = fmt.Errorf("otlp: collector returned %s", resp.Status)
+
+	//The statuses the OTLP spec says can be retried
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return retryableError{err}
+	}
+	return err
+}
+
+func (c *httpClient) close() error {
+	return nil
+}
@@ -0,0 +1,193 @@
+package otlp
+
+import (
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"google.golang.org/grpc/codes"
+)
+
+//scopeName is reported as the instrumentation scope of everything exported
+const scopeName = "github.com/troylelandshields/helloworld_grpctooling_poc"
+
+//encodeSpans builds an opentelemetry.proto.collector.trace.v1.ExportTraceServiceRequest
+func encodeSpans(resource []tracing.Attribute, spans []tracing.SpanData) []byte {
+	e := &encoder{}
+
+	//resource_spans
+	e.messageField(1, func(e *encoder) {
+		e.messageField(1, func(e *encoder) { encodeResource(e, resource) })
+
+		//scope_spans
+		e.messageField(2, func(e *encoder) {
+			e.messageField(1, encodeScope)
+			for _, span := range spans {
+				e.messageField(2, func(e *encoder) { encodeSpan(e, span) })
+			}
+		})
+	})
+
+	return e.b
+}
+
+//encodeSpan encodes an opentelemetry.proto.trace.v1.Span
+func encodeSpan(e *encoder, span tracing.SpanData) {
+	e.bytesField(1, span.Context.TraceID[:])
+	e.bytesField(2, span.Context.SpanID[:])
+	e.stringField(3, span.Context.TraceState)
+	if span.ParentSpanID.IsValid() {
+		e.bytesField(4, span.ParentSpanID[:])
+	}
+	e.stringField(5, span.Name)
+	e.uintField(6, spanKind(span.Kind))
+	e.fixed64Field(7, unixNano(span.Start))
+	e.fixed64Field(8, unixNano(span.End))
+
+	for _, a := range span.Attributes {
+		encodeAttribute(e, 9, a.Key, a.Value)
+	}
+	e.messageField(9, func(e *encoder) {
+		e.stringField(1, "rpc.grpc.status_code")
+		e.messageField(2, func(e *encoder) { e.oneofIntField(3, int64(span.Code)) })
+	})
+
+	for _, event := range span.Events {
+		e.messageField(11, func(e *encoder) {
+			e.fixed64Field(1, unixNano(event.Time))
+			e.stringField(2, event.Name)
+			for _, a := range event.Attributes {
+				encodeAttribute(e, 3, a.Key, a.Value)
+			}
+		})
+	}
+
+	//status, left unset unless the call failed
+	if span.Code != codes.OK {
+		e.messageField(15, func(e *encoder) {
+			e.stringField(2, span.Message)
+			e.uintField(3, 2) //STATUS_CODE_ERROR
+		})
+	}
+}
+
+func spanKind(k tracing.SpanKind) uint64 {
+	switch k {
+	case tracing.KindServer:
+		return 2
+	case tracing.KindClient:
+		return 3
+	}
+	return 1
+}
+
+//encodeMetrics builds an opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest.
+//Everything in the registry is cumulative since start.
+func encodeMetrics(resource []tracing.Attribute, families []metrics.Family, start, now time.Time) []byte {
+	e := &encoder{}
+
+	//resource_metrics
+	e.messageField(1, func(e *encoder) {
+		e.messageField(1, func(e *encoder) { encodeResource(e, resource) })
+
+		//scope_metrics
+		e.messageField(2, func(e *encoder) {
+			e.messageField(1, encodeScope)
+			for _, f := range families {
+				if len(f.Series) == 0 {
+					continue
+				}
+				e.messageField(2, func(e *encoder) { encodeMetric(e, f, unixNano(start), unixNano(now)) })
+			}
+		})
+	})
+
+	return e.b
+}
+
+//encodeMetric encodes an opentelemetry.proto.metrics.v1.Metric
+func encodeMetric(e *encoder, f metrics.Family, start, now uint64) {
+	e.stringField(1, f.Name)
+	e.stringField(2, f.Help)
+
+	numberPoints := func(e *encoder) {
+		for _, s := range f.Series {
+			e.messageField(1, func(e *encoder) {
+				e.fixed64Field(2, start)
+				e.fixed64Field(3, now)
+				e.oneofDoubleField(4, s.Value)
+				for i, label := range f.Labels {
+					encodeAttribute(e, 7, label, s.LabelValues[i])
+				}
+			})
+		}
+	}
+
+	switch f.Kind {
+	case "counter":
+		//sum
+		e.messageField(7, func(e *encoder) {
+			numberPoints(e)
+			e.uintField(2, 2) //AGGREGATION_TEMPORALITY_CUMULATIVE
+			e.boolField(3, true)
+		})
+	case "gauge":
+		e.messageField(5, numberPoints)
+	case "histogram":
+		e.messageField(9, func(e *encoder) {
+			for _, s := range f.Series {
+				e.messageField(1, func(e *encoder) { encodeHistogramPoint(e, f, s, start, now) })
+			}
+			e.uintField(2, 2) //AGGREGATION_TEMPORALITY_CUMULATIVE
+		})
+	}
+}
+
+//encodeHistogramPoint encodes an opentelemetry.proto.metrics.v1.HistogramDataPoint. OTLP wants one more bucket count than
+//there are bounds, for the observations above the last one.
+func encodeHistogramPoint(e *encoder, f metrics.Family, s metrics.Series, start, now uint64) {
+	counts := make([]uint64, 0, len(s.BucketCounts)+1)
+	var total uint64
+	for _, c := range s.BucketCounts {
+		counts = append(counts, c)
+		total += c
+	}
+	counts = append(counts, s.Count-total)
+
+	e.fixed64Field(2, start)
+	e.fixed64Field(3, now)
+	e.fixed64Field(4, s.Count)
+	e.oneofDoubleField(5, s.Value)
+	e.packedFixed64Field(6, counts)
+	e.packedDoubleField(7, f.Buckets)
+	for i, label := range f.Labels {
+		encodeAttribute(e, 9, label, s.LabelValues[i])
+	}
+}
+
+//encodeResource encodes an opentelemetry.proto.resource.v1.Resource
+func encodeResource(e *encoder, attributes []tracing.Attribute) {
+	for _, a := range attributes {
+		encodeAttribute(e, 1, a.Key, a.Value)
+	}
+}
+
+//encodeScope encodes an opentelemetry.proto.common.v1.InstrumentationScope
+func encodeScope(e *encoder) {
+	e.stringField(1, scopeName)
+}
+
+//encodeAttribute encodes a string opentelemetry.proto.common.v1.KeyValue as field
+func encodeAttribute(e *encoder, field int, key, value string) {
+	e.messageField(field, func(e *encoder) {
+		e.stringField(1, key)
+		e.messageField(2, func(e *encoder) { e.oneofStringField(1, value) })
+	})
+}
+
+func unixNano(t time.Time) uint64 {
+	if t.IsZero() {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
@@ -0,0 +1,345 @@
+//Package otlp exports spans and metrics to an OpenTelemetry collector over OTLP, using either gRPC or HTTP/protobuf.
+//Spans are queued and sent in batches from a background goroutine, so a slow or missing collector never holds up an RPC:
+//when the queue is full new spans are dropped and counted instead. A batch the collector keeps rejecting is retried
+//until the retry deadline and then dropped, so the batches behind it get their turn.
+package otlp
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+//Exporter is a tracing.Exporter that sends spans, and optionally a metrics registry, to a collector
+type Exporter struct {
+	client   client
+	resource []tracing.Attribute
+
+	batchSize    int
+	batchTimeout time.Duration
+	timeout      time.Duration
+	retry        retryPolicy
+	onError      func(error)
+
+	registry        *metrics.Registry
+	metricsInterval time.Duration
+	start           time.Time
+
+	headers  map[string]string
+	dialOpts []grpc.DialOption
+
+	queue   chan tracing.SpanData
+	dropped int64
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+	stopped  chan struct{}
+	shutdown sync.Once
+}
+
+type retryPolicy struct {
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	maxElapsed     time.Duration
+}
+
+//Option configures an Exporter
+type Option func(*Exporter)
+
+//WithServiceName sets the service.name resource attribute everything is reported under
+func WithServiceName(name string) Option {
+	return WithResource(tracing.Attribute{Key: "service.name", Value: name})
+}
+
+//WithResource adds attributes that describe where the telemetry comes from, e.g. host.name
+func WithResource(attributes ...tracing.Attribute) Option {
+	return func(e *Exporter) {
+		e.resource = append(e.resource, attributes...)
+	}
+}
+
+//WithBatchSize sets how many spans are sent at once. The default is 512.
+func WithBatchSize(n int) Option {
+	return func(e *Exporter) {
+		e.batchSize = n
+	}
+}
+
+//WithBatchTimeout sets how long spans can wait for a batch to fill up before it's sent anyway. The default is 5s.
+//It has to be positive.
+func WithBatchTimeout(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.batchTimeout = d
+	}
+}
+
+//WithQueueSize sets how many spans can wait to be sent before new ones are dropped. The default is 2048.
+func WithQueueSize(n int) Option {
+	return func(e *Exporter) {
+		e.queue = make(chan tracing.SpanData, n)
+	}
+}
+
+//WithTimeout limits each attempt to send a batch. The default is 10s.
+func WithTimeout(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.timeout = d
+	}
+}
+
+//WithRetry sets how many times a batch is tried when the collector is busy or unreachable, waiting initialBackoff
+//after the first failure and twice as long after each one after that, up to maxBackoff. The default is 5 attempts from 1s to 30s.
+func WithRetry(maxAttempts int, initialBackoff, maxBackoff time.Duration) Option {
+	return func(e *Exporter) {
+		e.retry.maxAttempts = maxAttempts
+		e.retry.initialBackoff = initialBackoff
+		e.retry.maxBackoff = maxBackoff
+	}
+}
+
+//WithRetryDeadline limits the total time spent on one batch, including every attempt and the waits between them.
+//Once it's up the batch is dropped and the error handler is called, so a collector that's down can't hold up the batches
+//behind it for longer than d. The default is 1m.
+func WithRetryDeadline(d time.Duration) Option {
+	return func(e *Exporter) {
+		e.retry.maxElapsed = d
+	}
+}
+
+//WithErrorHandler is called when a batch can't be sent. The default logs the error.
+func WithErrorHandler(f func(error)) Option {
+	return func(e *Exporter) {
+		e.onError = f
+	}
+}
+
+//WithMetrics sends everything in r every interval, which has to be positive
+func WithMetrics(r *metrics.Registry, interval time.Duration) Option {
+	return func(e *Exporter) {
+		e.registry = r
+		e.metricsInterval = interval
+	}
+}
+
+//WithHeaders are sent with every request, e.g. for an API key. They're gRPC metadata for NewGRPC and HTTP headers for NewHTTP.
+func WithHeaders(headers map[string]string) Option {
+	return func(e *Exporter) {
+		e.headers = headers
+	}
+}
+
+//WithDialOptions are used to dial the collector in NewGRPC instead of the default insecure connection, e.g. to use TLS
+func WithDialOptions(opts ...grpc.DialOption) Option {
+	return func(e *Exporter) {
+		e.dialOpts = append(e.dialOpts, opts...)
+	}
+}
+
+//NewGRPC creates an Exporter that sends to the collector's gRPC endpoint, e.g. "localhost:4317"
+func NewGRPC(target string, opts ...Option) (*Exporter, error) {
+	e, err := newExporter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	dialOpts := e.dialOpts
+	if len(dialOpts) == 0 {
+		dialOpts = []grpc.DialOption{grpc.WithInsecure()}
+	}
+	cc, err := grpc.Dial(target, append(dialOpts, grpc.WithCodec(rawCodec{}))...)
+	if err != nil {
+		return nil, err
+	}
+
+	e.client = &grpcClient{cc: cc, headers: metadata.New(e.headers)}
+	go e.run()
+	return e, nil
+}
+
+//NewHTTP creates an Exporter that sends protobuf to the collector's HTTP endpoint, e.g. "http://localhost:4318"
+func NewHTTP(endpoint string, opts ...Option) (*Exporter, error) {
+	e, err := newExporter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	e.client = &httpClient{
+		client:   &http.Client{},
+		endpoint: endpoint,
+		headers:  e.headers,
+	}
+	go e.run()
+	return e, nil
+}
+
+//newExporter applies the options and checks the intervals, which time.NewTicker panics on if they aren't positive
+func newExporter(opts []Option) (*Exporter, error) {
+	e := &Exporter{
+		batchSize:    512,
+		batchTimeout: 5 * time.Second,
+		timeout:      10 * time.Second,
+		retry:        retryPolicy{5, time.Second, 30 * time.Second, time.Minute},
+		onError: func(err error) {
+			log.Println("otlp: failed to export:", err)
+		},
+		start:   time.Now(),
+		queue:   make(chan tracing.SpanData, 2048),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+
+	if e.batchTimeout <= 0 {
+		return nil, fmt.Errorf("otlp: batch timeout must be positive, got %s", e.batchTimeout)
+	}
+	if e.registry != nil && e.metricsInterval <= 0 {
+		return nil, fmt.Errorf("otlp: metrics interval must be positive, got %s", e.metricsInterval)
+	}
+
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+	return e, nil
+}
+
+//ExportSpan queues the span to be sent. It never blocks: if the queue is full the span is dropped.
+func (e *Exporter) ExportSpan(span tracing.SpanData) {
+	select {
+	case e.queue <- span:
+	default:
+		atomic.AddInt64(&e.dropped, 1)
+	}
+}
+
+//Dropped returns the number of spans dropped because the queue was full
+func (e *Exporter) Dropped() int64 {
+	return atomic.LoadInt64(&e.dropped)
+}
+
+//run batches spans and sends them, and sends the metrics on their interval, until Shutdown
+func (e *Exporter) run() {
+	defer close(e.stopped)
+
+	batch := make([]tracing.SpanData, 0, e.batchSize)
+	flush := func() {
+		if len(batch) > 0 {
+			e.send(signalTraces, encodeSpans(e.resource, batch))
+			batch = batch[:0]
+		}
+	}
+
+	ticker := time.NewTicker(e.batchTimeout)
+	defer ticker.Stop()
+
+	var metricsTick <-chan time.Time
+	if e.registry != nil {
+		t := time.NewTicker(e.metricsInterval)
+		defer t.Stop()
+		metricsTick = t.C
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= e.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-metricsTick:
+			e.sendMetrics()
+		case <-e.done:
+			//Send what's left. Nothing else reads the queue, so this can't block.
+			for len(e.queue) > 0 {
+				batch = append(batch, <-e.queue)
+				if len(batch) >= e.batchSize {
+					flush()
+				}
+			}
+			flush()
+			if e.registry != nil {
+				e.sendMetrics()
+			}
+			return
+		}
+	}
+}
+
+func (e *Exporter) sendMetrics() {
+	e.send(signalMetrics, encodeMetrics(e.resource, e.registry.Snapshot(), e.start, time.Now()))
+}
+
+//send tries to send body, backing off between retryable failures until the retry deadline.
+//Each attempt gets the exporter's timeout, cut short if the deadline comes first.
+func (e *Exporter) send(sig signal, body []byte) {
+	backoff := e.retry.initialBackoff
+	deadline := time.Now().Add(e.retry.maxElapsed)
+	batchCtx, cancelBatch := context.WithDeadline(e.ctx, deadline)
+	defer cancelBatch()
+
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(batchCtx, e.timeout)
+		err := e.client.send(ctx, sig, body)
+		cancel()
+
+		if err == nil {
+			return
+		}
+		if !isRetryable(err) || attempt >= e.retry.maxAttempts {
+			e.onError(err)
+			return
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			e.onError(fmt.Errorf("otlp: gave up after %d attempts, retry deadline of %s reached: %v", attempt, e.retry.maxElapsed, err))
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-batchCtx.Done():
+			//Shutdown ran out of time
+			e.onError(err)
+			return
+		}
+
+		backoff *= 2
+		if backoff > e.retry.maxBackoff {
+			backoff = e.retry.maxBackoff
+		}
+	}
+}
+
+//Shutdown sends the spans that are queued and the metrics one last time, then closes the connection.
+//If ctx is done first, whatever hasn't been sent is dropped.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	var err error
+	e.shutdown.Do(func() {
+		close(e.done)
+
+		select {
+		case <-e.stopped:
+		case <-ctx.Done():
+			err = ctx.Err()
+			e.cancel()
+			<-e.stopped
+		}
+
+		e.cancel()
+		if cerr := e.client.close(); err == nil {
+			err = cerr
+		}
+	})
+	return err
+}
@@ -0,0 +1,541 @@
+package otlp
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/troylelandshields/helloworld_grpctooling_poc/metrics"
+	"github.com/troylelandshields/helloworld_grpctooling_poc/tracing"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+//The collector's ExportTraceServiceRequest and ExportMetricsServiceRequest have the same fields as TracesData and MetricsData,
+//so requests are decoded into those to keep the collector's gRPC service code out of the test.
+
+//request is one export the fake collector received
+type request struct {
+	path   string
+	header string
+	body   []byte
+}
+
+//grpcCollector is a fake OTLP/gRPC collector. fail is called with the attempt number and can return an error to reject it.
+type grpcCollector struct {
+	addr     string
+	requests chan request
+	fail     func(attempt int) error
+
+	mu       sync.Mutex
+	attempts int
+	server   *grpc.Server
+}
+
+func newGRPCCollector(t *testing.T, fail func(attempt int) error) *grpcCollector {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := &grpcCollector{
+		addr:     lis.Addr().String(),
+		requests: make(chan request, 10),
+		fail:     fail,
+	}
+	c.server = grpc.NewServer(grpc.CustomCodec(rawCodec{}), grpc.UnknownServiceHandler(c.handle))
+	go c.server.Serve(lis)
+	return c
+}
+
+func (c *grpcCollector) handle(srv interface{}, stream grpc.ServerStream) error {
+	method, _ := grpc.MethodFromServerStream(stream)
+
+	var body []byte
+	if err := stream.RecvMsg(&body); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	c.attempts++
+	attempt := c.attempts
+	c.mu.Unlock()
+
+	if c.fail != nil {
+		if err := c.fail(attempt); err != nil {
+			return err
+		}
+	}
+
+	var key string
+	if md, ok := metadata.FromContext(stream.Context()); ok && len(md["x-api-key"]) > 0 {
+		key = md["x-api-key"][0]
+	}
+	c.requests <- request{path: method, header: key, body: body}
+	return stream.SendMsg(&[]byte{})
+}
+
+func (c *grpcCollector) Attempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attempts
+}
+
+//httpCollector is a fake OTLP/HTTP collector. status is called with the attempt number and returns the status to reply with.
+type httpCollector struct {
+	*httptest.Server
+	requests chan request
+	status   func(attempt int) int
+
+	mu       sync.Mutex
+	attempts int
+}
+
+func newHTTPCollector(status func(attempt int) int) *httpCollector {
+	c := &httpCollector{
+		requests: make(chan request, 10),
+		status:   status,
+	}
+	c.Server = httptest.NewServer(http.HandlerFunc(c.handle))
+	return c
+}
+
+func (c *httpCollector) handle(w http.ResponseWriter, r *http.Request) {
+	body, _ := ioutil.ReadAll(r.Body)
+
+	c.mu.Lock()
+	c.attempts++
+	attempt := c.attempts
+	c.mu.Unlock()
+
+	if r.Header.Get("Content-Type") != "application/x-protobuf" {
+		w.WriteHeader(http.StatusUnsupportedMediaType)
+		return
+	}
+	if c.status != nil {
+		if status := c.status(attempt); status != http.StatusOK {
+			w.WriteHeader(status)
+			return
+		}
+	}
+
+	c.requests <- request{path: r.URL.Path, header: r.Header.Get("x-api-key"), body: body}
+}
+
+func (c *httpCollector) Attempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.attempts
+}
+
+func receive(t *testing.T, requests chan request) request {
+	select {
+	case req := <-requests:
+		return req
+	case <-time.After(5 * time.Second):
+		t.Fatal("the collector didn't get a request")
+	}
+	return request{}
+}
+
+func errorRecorder() (func(error), func() []error) {
+	var mu sync.Mutex
+	var errs []error
+	record := func(err error) {
+		mu.Lock()
+		errs = append(errs, err)
+		mu.Unlock()
+	}
+	recorded := func() []error {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]error(nil), errs...)
+	}
+	return record, recorded
+}
+
+func attributes(kvs []*commonpb.KeyValue) map[string]string {
+	m := map[string]string{}
+	for _, kv := range kvs {
+		m[kv.Key] = kv.Value.GetStringValue()
+	}
+	return m
+}
+
+func shutdown(t *testing.T, e *Exporter) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCExportSpans(t *testing.T) {
+	c := newGRPCCollector(t, nil)
+	defer c.server.Stop()
+
+	onError, errs := errorRecorder()
+	e, err := NewGRPC(c.addr,
+		WithServiceName("greeter"),
+		WithHeaders(map[string]string{"x-api-key": "secret"}),
+		WithBatchSize(2),
+		WithBatchTimeout(time.Hour),
+		WithErrorHandler(onError),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, e)
+
+	tracer := tracing.NewTracer(e)
+	ctx, parent := tracer.Start(context.Background(), "/helloworld.Greeter/SayHello", tracing.KindServer)
+	parent.SetAttribute("rpc.system", "grpc")
+	_, child := tracer.Start(ctx, "lookup", tracing.KindClient)
+	child.AddEvent("cache miss", tracing.Attribute{Key: "key", Value: "bob"})
+	child.SetStatus(grpc.Errorf(codes.NotFound, "no such user"))
+	child.End()
+	parent.End()
+
+	req := receive(t, c.requests)
+	if req.path != grpcMethods[signalTraces] {
+		t.Errorf("method = %q, want %q", req.path, grpcMethods[signalTraces])
+	}
+	if req.header != "secret" {
+		t.Errorf("x-api-key = %q, want secret", req.header)
+	}
+
+	var data tracepb.TracesData
+	if err := proto.Unmarshal(req.body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.ResourceSpans) != 1 || len(data.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("got %d resource spans, want 1 with 1 scope", len(data.ResourceSpans))
+	}
+	rs := data.ResourceSpans[0]
+	if got := attributes(rs.Resource.Attributes)["service.name"]; got != "greeter" {
+		t.Errorf("service.name = %q, want greeter", got)
+	}
+	if got := rs.ScopeSpans[0].Scope.Name; got != scopeName {
+		t.Errorf("scope = %q, want %q", got, scopeName)
+	}
+
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	gotChild, gotParent := spans[0], spans[1]
+
+	pc := parent.Context()
+	if string(gotParent.TraceId) != string(pc.TraceID[:]) || string(gotParent.SpanId) != string(pc.SpanID[:]) {
+		t.Errorf("parent IDs = %x/%x, want %s/%s", gotParent.TraceId, gotParent.SpanId, pc.TraceID, pc.SpanID)
+	}
+	if len(gotParent.ParentSpanId) != 0 {
+		t.Errorf("root span has parent %x", gotParent.ParentSpanId)
+	}
+	if gotParent.Name != "/helloworld.Greeter/SayHello" || gotParent.Kind != tracepb.Span_SPAN_KIND_SERVER {
+		t.Errorf("parent = %s %s, want /helloworld.Greeter/SayHello SERVER", gotParent.Name, gotParent.Kind)
+	}
+	if got := attributes(gotParent.Attributes); got["rpc.system"] != "grpc" {
+		t.Errorf("parent attributes = %v, want rpc.system=grpc", got)
+	}
+	if gotParent.Status != nil {
+		t.Errorf("parent status = %v, want unset", gotParent.Status)
+	}
+	if gotParent.EndTimeUnixNano < gotParent.StartTimeUnixNano || gotParent.StartTimeUnixNano == 0 {
+		t.Errorf("parent times = %d..%d", gotParent.StartTimeUnixNano, gotParent.EndTimeUnixNano)
+	}
+
+	if string(gotChild.TraceId) != string(pc.TraceID[:]) || string(gotChild.ParentSpanId) != string(pc.SpanID[:]) {
+		t.Errorf("child trace/parent = %x/%x, want %s/%s", gotChild.TraceId, gotChild.ParentSpanId, pc.TraceID, pc.SpanID)
+	}
+	if gotChild.Kind != tracepb.Span_SPAN_KIND_CLIENT {
+		t.Errorf("child kind = %s, want CLIENT", gotChild.Kind)
+	}
+	if len(gotChild.Events) != 1 || gotChild.Events[0].Name != "cache miss" || attributes(gotChild.Events[0].Attributes)["key"] != "bob" {
+		t.Errorf("child events = %v, want cache miss key=bob", gotChild.Events)
+	}
+	if gotChild.Status.GetCode() != tracepb.Status_STATUS_CODE_ERROR || gotChild.Status.GetMessage() != "no such user" {
+		t.Errorf("child status = %v, want ERROR no such user", gotChild.Status)
+	}
+	for _, kv := range gotChild.Attributes {
+		if kv.Key == "rpc.grpc.status_code" && kv.Value.GetIntValue() != int64(codes.NotFound) {
+			t.Errorf("rpc.grpc.status_code = %d, want %d", kv.Value.GetIntValue(), codes.NotFound)
+		}
+	}
+
+	if got := errs(); len(got) != 0 {
+		t.Errorf("errors: %v", got)
+	}
+}
+
+func TestHTTPExportMetrics(t *testing.T) {
+	c := newHTTPCollector(nil)
+	defer c.Close()
+
+	r := metrics.NewRegistry()
+	r.Counter("calls_total", "Calls.", "method").Add(3, "/a")
+	r.Gauge("open", "Open streams.").Set(7)
+	h := r.Histogram("latency_seconds", "Latency.", []float64{1, 5}, "method")
+	for _, v := range []float64{0.5, 3, 10} {
+		h.Observe(v, "/a")
+	}
+
+	onError, errs := errorRecorder()
+	e, err := NewHTTP(c.URL,
+		WithServiceName("greeter"),
+		WithHeaders(map[string]string{"x-api-key": "secret"}),
+		WithMetrics(r, time.Hour),
+		WithErrorHandler(onError),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Shutdown sends the metrics one last time
+	shutdown(t, e)
+
+	req := receive(t, c.requests)
+	if req.path != "/v1/metrics" {
+		t.Errorf("path = %q, want /v1/metrics", req.path)
+	}
+	if req.header != "secret" {
+		t.Errorf("x-api-key = %q, want secret", req.header)
+	}
+
+	var data metricspb.MetricsData
+	if err := proto.Unmarshal(req.body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if len(data.ResourceMetrics) != 1 || len(data.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("got %d resource metrics, want 1 with 1 scope", len(data.ResourceMetrics))
+	}
+	rm := data.ResourceMetrics[0]
+	if got := attributes(rm.Resource.Attributes)["service.name"]; got != "greeter" {
+		t.Errorf("service.name = %q, want greeter", got)
+	}
+
+	byName := map[string]*metricspb.Metric{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		byName[m.Name] = m
+	}
+
+	sum := byName["calls_total"].GetSum()
+	if sum == nil || !sum.IsMonotonic || sum.AggregationTemporality != metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE {
+		t.Fatalf("calls_total = %v, want a cumulative monotonic sum", byName["calls_total"])
+	}
+	if len(sum.DataPoints) != 1 || sum.DataPoints[0].GetAsDouble() != 3 || attributes(sum.DataPoints[0].Attributes)["method"] != "/a" {
+		t.Errorf("calls_total points = %v, want 3 for method=/a", sum.DataPoints)
+	}
+	if byName["calls_total"].Description != "Calls." {
+		t.Errorf("calls_total description = %q", byName["calls_total"].Description)
+	}
+
+	gauge := byName["open"].GetGauge()
+	if gauge == nil || len(gauge.DataPoints) != 1 || gauge.DataPoints[0].GetAsDouble() != 7 {
+		t.Errorf("open = %v, want a gauge of 7", byName["open"])
+	}
+
+	hist := byName["latency_seconds"].GetHistogram()
+	if hist == nil || len(hist.DataPoints) != 1 {
+		t.Fatalf("latency_seconds = %v, want a histogram with one point", byName["latency_seconds"])
+	}
+	p := hist.DataPoints[0]
+	if p.Count != 3 || p.GetSum() != 13.5 {
+		t.Errorf("latency_seconds count/sum = %d/%v, want 3/13.5", p.Count, p.GetSum())
+	}
+	if len(p.ExplicitBounds) != 2 || p.ExplicitBounds[0] != 1 || p.ExplicitBounds[1] != 5 {
+		t.Errorf("latency_seconds bounds = %v, want [1 5]", p.ExplicitBounds)
+	}
+	if len(p.BucketCounts) != 3 || p.BucketCounts[0] != 1 || p.BucketCounts[1] != 1 || p.BucketCounts[2] != 1 {
+		t.Errorf("latency_seconds buckets = %v, want [1 1 1]", p.BucketCounts)
+	}
+
+	if got := errs(); len(got) != 0 {
+		t.Errorf("errors: %v", got)
+	}
+}
+
+func TestGRPCRetryUnavailable(t *testing.T) {
+	c := newGRPCCollector(t, func(attempt int) error {
+		if attempt < 3 {
+			return grpc.Errorf(codes.Unavailable, "busy")
+		}
+		return nil
+	})
+	defer c.server.Stop()
+
+	onError, errs := errorRecorder()
+	e, err := NewGRPC(c.addr, WithBatchSize(1), WithRetry(5, time.Millisecond, time.Millisecond), WithErrorHandler(onError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, e)
+
+	e.ExportSpan(tracing.SpanData{Name: "retried"})
+	receive(t, c.requests)
+
+	if got := c.Attempts(); got != 3 {
+		t.Errorf("attempts = %d, want 3", got)
+	}
+	if got := errs(); len(got) != 0 {
+		t.Errorf("errors: %v", got)
+	}
+}
+
+func TestGRPCNoRetryOnInvalidArgument(t *testing.T) {
+	c := newGRPCCollector(t, func(attempt int) error {
+		return grpc.Errorf(codes.InvalidArgument, "bad")
+	})
+	defer c.server.Stop()
+
+	onError, errs := errorRecorder()
+	e, err := NewGRPC(c.addr, WithBatchSize(1), WithRetry(5, time.Millisecond, time.Millisecond), WithErrorHandler(onError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.ExportSpan(tracing.SpanData{Name: "rejected"})
+	shutdown(t, e)
+
+	if got := c.Attempts(); got != 1 {
+		t.Errorf("attempts = %d, want 1", got)
+	}
+	if got := errs(); len(got) != 1 || grpc.Code(got[0]) != codes.InvalidArgument {
+		t.Errorf("errors = %v, want one InvalidArgument", got)
+	}
+}
+
+func TestHTTPRetry503(t *testing.T) {
+	c := newHTTPCollector(func(attempt int) int {
+		if attempt == 1 {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+	defer c.Close()
+
+	onError, errs := errorRecorder()
+	e, err := NewHTTP(c.URL, WithBatchSize(1), WithRetry(5, time.Millisecond, time.Millisecond), WithErrorHandler(onError))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(t, e)
+
+	e.ExportSpan(tracing.SpanData{Name: "retried"})
+	req := receive(t, c.requests)
+	if req.path != "/v1/traces" {
+		t.Errorf("path = %q, want /v1/traces", req.path)
+	}
+
+	if got := c.Attempts(); got != 2 {
+		t.Errorf("attempts = %d, want 2", got)
+	}
+	if got := errs(); len(got) != 0 {
+		t.Errorf("errors: %v", got)
+	}
+}
+
+func TestHTTPRetryGivesUp(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     []Option
+		min, max int
+	}{
+		{"max attempts", []Option{WithRetry(3, time.Millisecond, time.Millisecond)}, 3, 3},
+		//attempts at 0, 40ms and 80ms, then the next backoff would pass the deadline
+		{"deadline", []Option{WithRetry(100, 40*time.Millisecond, 40*time.Millisecond), WithRetryDeadline(100 * time.Millisecond)}, 2, 3},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newHTTPCollector(func(attempt int) int {
+				return http.StatusServiceUnavailable
+			})
+			defer c.Close()
+
+			onError, errs := errorRecorder()
+			e, err := NewHTTP(c.URL, append(test.opts, WithBatchSize(1), WithErrorHandler(onError))...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.ExportSpan(tracing.SpanData{Name: "dropped"})
+			shutdown(t, e)
+
+			if got := c.Attempts(); got < test.min || got > test.max {
+				t.Errorf("attempts = %d, want %d to %d", got, test.min, test.max)
+			}
+			if got := errs(); len(got) != 1 {
+				t.Errorf("errors = %v, want 1", got)
+			}
+		})
+	}
+}
+
+func TestExportSpanNeverBlocks(t *testing.T) {
+	release := make(chan struct{})
+	c := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer c.Close()
+
+	e, err := NewHTTP(c.URL, WithBatchSize(1), WithQueueSize(2), WithErrorHandler(func(error) {}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const spans = 100
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < spans; i++ {
+			e.ExportSpan(tracing.SpanData{Name: "span"})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("ExportSpan blocked while the collector was stuck")
+	}
+
+	//At most one batch is being sent and two spans are queued
+	if got := e.Dropped(); got < spans-3 {
+		t.Errorf("dropped = %d, want at least %d", got, spans-3)
+	}
+
+	close(release)
+	shutdown(t, e)
+}
+
+func TestIntervalsMustBePositive(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []Option
+		wantErr string
+	}{
+		{"zero batch timeout", []Option{WithBatchTimeout(0)}, "batch timeout must be positive, got 0s"},
+		{"negative batch timeout", []Option{WithBatchTimeout(-time.Second)}, "batch timeout must be positive, got -1s"},
+		{"zero metrics interval", []Option{WithMetrics(metrics.NewRegistry(), 0)}, "metrics interval must be positive, got 0s"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//these used to panic in time.NewTicker on the exporter's goroutine
+			if _, err := NewHTTP("http://localhost:4318", test.opts...); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("NewHTTP err = %v, want %q", err, test.wantErr)
+			}
+			if _, err := NewGRPC("localhost:4317", test.opts...); err == nil || !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("NewGRPC err = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package otlp
+
+import "math"
+
+//The OTLP messages are small and fixed, so they're encoded by hand instead of pulling in the generated protobuf packages.
+//See https://developers.google.com/protocol-buffers/docs/encoding for the wire format.
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) varint(v uint64) {
+	for v >= 0x80 {
+		e.b = append(e.b, byte(v)|0x80)
+		v >>= 7
+	}
+	e.b = append(e.b, byte(v))
+}
+
+func (e *encoder) fixed64(v uint64) {
+	e.b = append(e.b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24), byte(v>>32), byte(v>>40), byte(v>>48), byte(v>>56))
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+func (e *encoder) uintField(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireVarint)
+	e.varint(v)
+}
+
+func (e *encoder) boolField(field int, v bool) {
+	if v {
+		e.uintField(field, 1)
+	}
+}
+
+func (e *encoder) fixed64Field(field int, v uint64) {
+	if v == 0 {
+		return
+	}
+	e.tag(field, wireFixed64)
+	e.fixed64(v)
+}
+
+func (e *encoder) bytesField(field int, v []byte) {
+	if len(v) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) stringField(field int, v string) {
+	if v == "" {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+//oneofStringField, oneofIntField and oneofDoubleField write the value even when it's the zero value,
+//since a oneof or optional field that isn't written counts as not set
+func (e *encoder) oneofStringField(field int, v string) {
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(v)))
+	e.b = append(e.b, v...)
+}
+
+func (e *encoder) oneofIntField(field int, v int64) {
+	e.tag(field, wireVarint)
+	e.varint(uint64(v))
+}
+
+func (e *encoder) oneofDoubleField(field int, v float64) {
+	e.tag(field, wireFixed64)
+	e.fixed64(math.Float64bits(v))
+}
+
+//messageField encodes a nested message. Empty messages are still written since their presence can matter, e.g. an AnyValue.
+func (e *encoder) messageField(field int, encode func(*encoder)) {
+	nested := &encoder{}
+	encode(nested)
+
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(nested.b)))
+	e.b = append(e.b, nested.b...)
+}
+
+func (e *encoder) packedFixed64Field(field int, vs []uint64) {
+	if len(vs) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(vs) * 8))
+	for _, v := range vs {
+		e.fixed64(v)
+	}
+}
+
+func (e *encoder) packedDoubleField(field int, vs []float64) {
+	if len(vs) == 0 {
+		return
+	}
+	e.tag(field, wireBytes)
+	e.varint(uint64(len(vs) * 8))
+	for _, v := range vs {
+		e.fixed64(math.Float64bits(v))
+	}
+}
@@ -0,0 +1,111 @@
+package tracing
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+)
+
+//The W3C trace context headers, see https://www.w3.org/TR/trace-context/
+const (
+	TraceparentKey = "traceparent"
+	TracestateKey  = "tracestate"
+)
+
+const sampledFlag = 0x01
+
+var errBadTraceparent = errors.New("malformed traceparent")
+
+//ParseTraceparent reads a traceparent header like 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+
+	//Later versions may add fields after the flags, but the first four have to look the same
+	if len(s) < 55 || (len(s) > 55 && s[55] != '-') {
+		return sc, errBadTraceparent
+	}
+	if s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, errBadTraceparent
+	}
+
+	version, ok := decodeHex(s[0:2])
+	if !ok || version[0] == 0xff || (version[0] == 0 && len(s) != 55) {
+		return sc, errBadTraceparent
+	}
+
+	traceID, ok := decodeHex(s[3:35])
+	if !ok {
+		return sc, errBadTraceparent
+	}
+	copy(sc.TraceID[:], traceID)
+
+	spanID, ok := decodeHex(s[36:52])
+	if !ok {
+		return sc, errBadTraceparent
+	}
+	copy(sc.SpanID[:], spanID)
+
+	flags, ok := decodeHex(s[53:55])
+	if !ok {
+		return sc, errBadTraceparent
+	}
+	sc.Sampled = flags[0]&sampledFlag != 0
+
+	if !sc.IsValid() {
+		return sc, errBadTraceparent
+	}
+	return sc, nil
+}
+
+//decodeHex only accepts lowercase hex, as the spec requires
+func decodeHex(s string) ([]byte, bool) {
+	if strings.ToLower(s) != s {
+		return nil, false
+	}
+	b, err := hex.DecodeString(s)
+	return b, err == nil
+}
+
+//Traceparent formats the span context as a version 00 traceparent header
+func (sc SpanContext) Traceparent() string {
+	var flags byte
+	if sc.Sampled {
+		flags |= sampledFlag
+	}
+	return fmt.Sprintf("00-%s-%s-%02x", sc.TraceID, sc.SpanID, flags)
+}
+
+//Extract reads the span context from traceparent and tracestate in md. A missing or malformed traceparent returns false
+//and the caller should start a new trace; tracestate is ignored without a traceparent.
+func Extract(md metadata.MD) (SpanContext, bool) {
+	values := md[TraceparentKey]
+	if len(values) == 0 {
+		return SpanContext{}, false
+	}
+
+	sc, err := ParseTraceparent(values[0])
+	if err != nil {
+		return SpanContext{}, false
+	}
+
+	//Multiple tracestate headers are combined into one list
+	sc.TraceState = strings.Join(md[TracestateKey], ",")
+	return sc, true
+}
+
+//Inject writes the span context into md as traceparent and tracestate
+func Inject(md metadata.MD, sc SpanContext) {
+	if !sc.IsValid() {
+		return
+	}
+
+	md[TraceparentKey] = []string{sc.Traceparent()}
+	if sc.TraceState != "" {
+		md[TracestateKey] = []string{sc.TraceState}
+	} else {
+		delete(md, TracestateKey)
+	}
+}
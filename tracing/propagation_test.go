@@ -0,0 +1,131 @@
+package tracing
+
+import (
+	"reflect"
+	"testing"
+
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	spanID  = "00f067aa0ba902b7"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		ok      bool
+		sampled bool
+	}{
+		{"sampled", "00-" + traceID + "-" + spanID + "-01", true, true},
+		{"not sampled", "00-" + traceID + "-" + spanID + "-00", true, false},
+		{"other flags are ignored", "00-" + traceID + "-" + spanID + "-fe", true, false},
+
+		{"version ff", "ff-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase version", "0A-" + traceID + "-" + spanID + "-01", false, false},
+		{"uppercase trace id", "00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false, false},
+		{"uppercase span id", "00-" + traceID + "-00F067AA0BA902B7-01", false, false},
+		{"uppercase flags", "00-" + traceID + "-" + spanID + "-0A", false, false},
+		{"zero trace id", "00-00000000000000000000000000000000-" + spanID + "-01", false, false},
+		{"zero span id", "00-" + traceID + "-0000000000000000-01", false, false},
+		{"not hex", "00-" + traceID + "-" + spanID + "-zz", false, false},
+
+		{"empty", "", false, false},
+		{"short trace id", "00-" + traceID[1:] + "-" + spanID + "-01", false, false},
+		{"long span id", "00-" + traceID + "-" + spanID + "0-01", false, false},
+		{"missing flags", "00-" + traceID + "-" + spanID, false, false},
+		{"wrong separator", "00_" + traceID + "-" + spanID + "-01", false, false},
+
+		//version 00 is exactly 55 characters, later versions may add fields after another dash
+		{"version 00 with more fields", "00-" + traceID + "-" + spanID + "-01-extra", false, false},
+		{"version 00 with trailing characters", "00-" + traceID + "-" + spanID + "-01x", false, false},
+		{"future version", "01-" + traceID + "-" + spanID + "-01", true, true},
+		{"future version with more fields", "cc-" + traceID + "-" + spanID + "-01-what-the-future-holds", true, true},
+		{"future version without a dash", "cc-" + traceID + "-" + spanID + "-01what", false, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sc, err := ParseTraceparent(test.header)
+			if (err == nil) != test.ok {
+				t.Fatalf("ParseTraceparent(%q) err = %v, want ok %v", test.header, err, test.ok)
+			}
+			if !test.ok {
+				return
+			}
+			if sc.TraceID.String() != traceID || sc.SpanID.String() != spanID {
+				t.Errorf("ids = %s %s, want %s %s", sc.TraceID, sc.SpanID, traceID, spanID)
+			}
+			if sc.Sampled != test.sampled {
+				t.Errorf("sampled = %v, want %v", sc.Sampled, test.sampled)
+			}
+		})
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	for _, header := range []string{"00-" + traceID + "-" + spanID + "-01", "00-" + traceID + "-" + spanID + "-00"} {
+		sc, err := ParseTraceparent(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := sc.Traceparent(); got != header {
+			t.Errorf("Traceparent() = %q, want %q", got, header)
+		}
+	}
+}
+
+func TestExtractInject(t *testing.T) {
+	sc, err := ParseTraceparent("00-" + traceID + "-" + spanID + "-01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc.TraceState = "vendor=a,other=b"
+
+	md := metadata.MD{}
+	Inject(md, sc)
+	got, ok := Extract(md)
+	if !ok || !reflect.DeepEqual(got, sc) {
+		t.Errorf("Extract(Inject(%+v)) = %+v, %v", sc, got, ok)
+	}
+
+	//a stale tracestate isn't left behind when the new span has none
+	sc.TraceState = ""
+	Inject(md, sc)
+	if _, ok := md[TracestateKey]; ok {
+		t.Errorf("tracestate = %q, want it removed", md[TracestateKey])
+	}
+
+	//an invalid span context isn't sent at all
+	empty := metadata.MD{}
+	Inject(empty, SpanContext{})
+	if len(empty) != 0 {
+		t.Errorf("md = %v, want nothing injected for an invalid span context", empty)
+	}
+}
+
+func TestExtract(t *testing.T) {
+	valid := "00-" + traceID + "-" + spanID + "-01"
+
+	tests := []struct {
+		name  string
+		md    metadata.MD
+		ok    bool
+		state string
+	}{
+		{"none", metadata.MD{}, false, ""},
+		{"malformed", metadata.Pairs(TraceparentKey, "00-nope"), false, ""},
+		{"tracestate without traceparent", metadata.Pairs(TracestateKey, "vendor=a"), false, ""},
+		{"no tracestate", metadata.Pairs(TraceparentKey, valid), true, ""},
+		{"tracestate headers are combined", metadata.Pairs(TraceparentKey, valid, TracestateKey, "a=1", TracestateKey, "b=2"), true, "a=1,b=2"},
+	}
+
+	for _, test := range tests {
+		sc, ok := Extract(test.md)
+		if ok != test.ok || sc.TraceState != test.state {
+			t.Errorf("%s: Extract = %+v, %v, want ok %v and tracestate %q", test.name, sc, ok, test.ok, test.state)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+//Package tracing creates spans for RPCs and passes their context between services in W3C traceparent/tracestate headers
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+//TraceID identifies a trace, every span in it shares the ID
+type TraceID [16]byte
+
+func (t TraceID) String() string {
+	return hex.EncodeToString(t[:])
+}
+
+//IsValid reports whether the ID is set. An all zero ID isn't allowed.
+func (t TraceID) IsValid() bool {
+	return t != TraceID{}
+}
+
+//SpanID identifies a span within a trace
+type SpanID [8]byte
+
+func (s SpanID) String() string {
+	return hex.EncodeToString(s[:])
+}
+
+//IsValid reports whether the ID is set. An all zero ID isn't allowed.
+func (s SpanID) IsValid() bool {
+	return s != SpanID{}
+}
+
+//SpanContext is the part of a span that is passed on to other services
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	//Sampled spans are sent to the exporters
+	Sampled bool
+	//TraceState is vendor specific data that is passed along untouched
+	TraceState string
+}
+
+//IsValid reports whether both IDs are set
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID.IsValid() && sc.SpanID.IsValid()
+}
+
+//SpanKind says what side of an RPC a span is for
+type SpanKind int
+
+const (
+	KindInternal SpanKind = iota
+	KindServer
+	KindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case KindServer:
+		return "server"
+	case KindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+//Attribute annotates a span or an event
+type Attribute struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+//Event is something that happened at a point in time during a span
+type Event struct {
+	Name       string      `json:"name"`
+	Time       time.Time   `json:"time"`
+	Attributes []Attribute `json:"attributes,omitempty"`
+}
+
+//Span is one timed operation in a trace. Its methods are safe to call on a nil Span, so handlers can annotate
+//SpanFromContext(ctx) without checking whether tracing is turned on.
+type Span struct {
+	tracer  *Tracer
+	context SpanContext
+	parent  SpanID
+	name    string
+	kind    SpanKind
+	start   time.Time
+
+	mu         sync.Mutex
+	ended      bool
+	attributes []Attribute
+	events     []Event
+	code       codes.Code
+	message    string
+}
+
+//SpanData is a snapshot of a finished span, which is what exporters get
+type SpanData struct {
+	Name         string      `json:"name"`
+	Kind         SpanKind    `json:"kind"`
+	Context      SpanContext `json:"-"`
+	ParentSpanID SpanID      `json:"-"`
+	Start        time.Time   `json:"start"`
+	End          time.Time   `json:"end"`
+	Attributes   []Attribute `json:"attributes,omitempty"`
+	Events       []Event     `json:"events,omitempty"`
+	//Code is the gRPC status code of the operation, codes.OK unless SetStatus was given an error
+	Code    codes.Code `json:"code"`
+	Message string     `json:"message,omitempty"`
+}
+
+//Context returns the span's IDs
+func (s *Span) Context() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.context
+}
+
+//SetAttribute adds a key/value pair to the span
+func (s *Span) SetAttribute(key, value string) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.attributes = append(s.attributes, Attribute{key, value})
+	s.mu.Unlock()
+}
+
+//AddEvent records that something happened now
+func (s *Span) AddEvent(name string, attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.events = append(s.events, Event{Name: name, Time: time.Now(), Attributes: attributes})
+	s.mu.Unlock()
+}
+
+//SetStatus records the outcome of the operation from the error it returned. A nil error is OK.
+func (s *Span) SetStatus(err error) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.code = grpc.Code(err)
+	s.message = grpc.ErrorDesc(err)
+	s.mu.Unlock()
+}
+
+//End finishes the span and sends it to the tracer's exporters if it's sampled. Only the first call does anything.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+
+	data := SpanData{
+		Name:         s.name,
+		Kind:         s.kind,
+		Context:      s.context,
+		ParentSpanID: s.parent,
+		Start:        s.start,
+		End:          time.Now(),
+		Attributes:   s.attributes,
+		Events:       s.events,
+		Code:         s.code,
+		Message:      s.message,
+	}
+	s.mu.Unlock()
+
+	if s.context.Sampled {
+		s.tracer.export(data)
+	}
+}
+
+type spanKey struct{}
+
+type remoteParentKey struct{}
+
+//ContextWithSpan returns a ctx carrying span, which becomes the parent of spans started from it
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanKey{}, span)
+}
+
+//SpanFromContext returns the current span, or nil if there isn't one
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+//ContextWithRemoteParent returns a ctx that spans started from it will be children of. It's used for a parent span that
+//lives in another service, e.g. one read from the traceparent header.
+func ContextWithRemoteParent(ctx context.Context, sc SpanContext) context.Context {
+	return context.WithValue(ctx, remoteParentKey{}, sc)
+}
+
+//SpanContextFromContext returns the IDs of the current span, or of the remote parent if no span has been started yet
+func SpanContextFromContext(ctx context.Context) (SpanContext, bool) {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.context, true
+	}
+
+	sc, ok := ctx.Value(remoteParentKey{}).(SpanContext)
+	return sc, ok && sc.IsValid()
+}
+
+//newTraceID and newSpanID use crypto/rand like the request IDs. A failed read leaves the ID invalid, which only means the span won't be propagated.
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+//Exporter receives every sampled span when it ends. ExportSpan is called on the goroutine that ended the span,
+//so it shouldn't block; exporters that send spans somewhere should queue them.
+type Exporter interface {
+	ExportSpan(SpanData)
+}
+
+//Tracer starts spans and hands them to its exporters when they end
+type Tracer struct {
+	mu        sync.RWMutex
+	exporters []Exporter
+}
+
+//DefaultTracer is used by the tracing middleware unless it's given another one. It has no exporters until one is added.
+var DefaultTracer = NewTracer()
+
+//NewTracer creates a Tracer that sends spans to the exporters
+func NewTracer(exporters ...Exporter) *Tracer {
+	return &Tracer{exporters: exporters}
+}
+
+//AddExporter adds an exporter for the spans that end from now on
+func (t *Tracer) AddExporter(e Exporter) {
+	t.mu.Lock()
+	t.exporters = append(t.exporters, e)
+	t.mu.Unlock()
+}
+
+func (t *Tracer) export(data SpanData) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, e := range t.exporters {
+		e.ExportSpan(data)
+	}
+}
+
+//Start starts a span that is a child of the span in ctx, or of the remote parent in ctx, or the root of a new trace.
+//The returned ctx carries the new span. End has to be called on it.
+func (t *Tracer) Start(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	span := &Span{
+		tracer: t,
+		name:   name,
+		kind:   kind,
+		start:  time.Now(),
+	}
+
+	if parent, ok := SpanContextFromContext(ctx); ok {
+		span.context = parent
+		span.parent = parent.SpanID
+	} else {
+		span.context = SpanContext{TraceID: newTraceID(), Sampled: true}
+	}
+	span.context.SpanID = newSpanID()
+
+	return ContextWithSpan(ctx, span), span
+}
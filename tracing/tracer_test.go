@@ -0,0 +1,146 @@
+package tracing
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+)
+
+//recorder is an Exporter that keeps the spans it's given
+type recorder struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func (r *recorder) ExportSpan(data SpanData) {
+	r.mu.Lock()
+	r.spans = append(r.spans, data)
+	r.mu.Unlock()
+}
+
+func (r *recorder) exported() []SpanData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]SpanData(nil), r.spans...)
+}
+
+func TestStartParenting(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracer(rec)
+
+	ctx, root := tr.Start(context.Background(), "root", KindServer)
+	if !root.Context().IsValid() || !root.Context().Sampled {
+		t.Fatalf("root = %+v, want a valid sampled span", root.Context())
+	}
+
+	_, child := tr.Start(ctx, "child", KindInternal)
+	child.End()
+	root.End()
+
+	spans := rec.exported()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	c, r := spans[0], spans[1]
+	if c.Context.TraceID != r.Context.TraceID {
+		t.Error("child isn't in the root's trace")
+	}
+	if c.ParentSpanID != r.Context.SpanID || r.ParentSpanID.IsValid() {
+		t.Errorf("parents = %s and %s, want the root's span and none", c.ParentSpanID, r.ParentSpanID)
+	}
+	if c.Context.SpanID == r.Context.SpanID {
+		t.Error("child has the root's span ID")
+	}
+}
+
+func TestStartRemoteParent(t *testing.T) {
+	for _, sampled := range []bool{true, false} {
+		rec := &recorder{}
+		tr := NewTracer(rec)
+
+		parent, err := ParseTraceparent("00-" + traceID + "-" + spanID + "-00")
+		if err != nil {
+			t.Fatal(err)
+		}
+		parent.Sampled = sampled
+		parent.TraceState = "vendor=a"
+
+		ctx, span := tr.Start(ContextWithRemoteParent(context.Background(), parent), "server", KindServer)
+		if sc, _ := SpanContextFromContext(ctx); sc != span.Context() {
+			t.Errorf("ctx carries %+v, want the new span", sc)
+		}
+		span.End()
+
+		spans := rec.exported()
+		if !sampled {
+			//the caller decided not to sample the trace, so nothing in it is exported
+			if len(spans) != 0 {
+				t.Errorf("exported %d spans of an unsampled trace", len(spans))
+			}
+			continue
+		}
+		if len(spans) != 1 {
+			t.Fatalf("got %d spans, want 1", len(spans))
+		}
+		s := spans[0]
+		if s.Context.TraceID.String() != traceID || s.ParentSpanID.String() != spanID || s.Context.TraceState != "vendor=a" {
+			t.Errorf("span = %+v, want it to continue the remote trace", s)
+		}
+	}
+}
+
+func TestSpanEnd(t *testing.T) {
+	rec := &recorder{}
+	tr := NewTracer(rec)
+
+	_, span := tr.Start(context.Background(), "op", KindClient)
+	span.SetAttribute("key", "value")
+	span.AddEvent("happened", Attribute{"n", "1"})
+	span.SetStatus(grpc.Errorf(codes.NotFound, "no such thing"))
+	span.End()
+	//ending twice only exports once, and changes after the end aren't exported
+	span.SetAttribute("late", "true")
+	span.End()
+
+	spans := rec.exported()
+	if len(spans) != 1 {
+		t.Fatalf("got %d spans, want 1", len(spans))
+	}
+	s := spans[0]
+	if s.Name != "op" || s.Kind != KindClient || s.Code != codes.NotFound || s.Message != "no such thing" {
+		t.Errorf("span = %+v", s)
+	}
+	if len(s.Attributes) != 1 || s.Attributes[0] != (Attribute{"key", "value"}) {
+		t.Errorf("attributes = %v", s.Attributes)
+	}
+	if len(s.Events) != 1 || s.Events[0].Name != "happened" {
+		t.Errorf("events = %v", s.Events)
+	}
+	if s.End.Before(s.Start) {
+		t.Errorf("ended at %s, before it started at %s", s.End, s.Start)
+	}
+
+	//a plain error is Unknown
+	_, span = tr.Start(context.Background(), "op", KindInternal)
+	span.SetStatus(errors.New("broken"))
+	span.End()
+	if s := rec.exported()[1]; s.Code != codes.Unknown {
+		t.Errorf("code = %s, want Unknown", s.Code)
+	}
+}
+
+func TestNilSpan(t *testing.T) {
+	//handlers annotate SpanFromContext without checking whether tracing is on
+	span := SpanFromContext(context.Background())
+	span.SetAttribute("key", "value")
+	span.AddEvent("happened")
+	span.SetStatus(errors.New("broken"))
+	span.End()
+	if span.Context().IsValid() {
+		t.Error("nil span has a valid context")
+	}
+}